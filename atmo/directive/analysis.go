@@ -0,0 +1,84 @@
+package directive
+
+import "sort"
+
+// StepAnalysis describes how a single step of a handler interacts with the state
+type StepAnalysis struct {
+	// AvailableBefore are the state keys available when the step starts
+	AvailableBefore []string
+
+	// Produces are the state keys that the step adds (or overwrites)
+	Produces []string
+
+	// References are the state keys that the step reads, via 'with', 'when', 'in', or 'response' values
+	References []string
+}
+
+// AnalyzeHandler walks the handler's steps in the same way as Validate, returning the state keys
+// available to, produced by, and referenced by each step. The keys produced by the Directive's middleware
+// are available before the first step. All of the lists are sorted
+func (d *Directive) AnalyzeHandler(h Handler) []StepAnalysis {
+	available := d.middlewareKeys()
+	for k := range h.State {
+		available[k] = true
+	}
+
+	analysis := make([]StepAnalysis, len(h.Steps))
+
+	for j, s := range h.Steps {
+		produces, references := map[string]bool{}, map[string]bool{}
+
+		if s.IsForEach() && s.ForEach.In != "" {
+			references[s.ForEach.In] = true
+		}
+
+		for _, fn := range s.callableFns() {
+			for _, key := range fn.stateKeys() {
+				references[key] = true
+			}
+
+			produces[fn.key()] = true
+		}
+
+		if s.Response != "" {
+			references[s.responseKey()] = true
+		}
+
+		analysis[j] = StepAnalysis{
+			AvailableBefore: sortedKeys(available),
+			Produces:        sortedKeys(produces),
+			References:      sortedKeys(references),
+		}
+
+		for key := range produces {
+			available[key] = true
+		}
+	}
+
+	return analysis
+}
+
+// middlewareKeys returns the state keys produced by the Directive's middleware, which are available to every handler
+func (d *Directive) middlewareKeys() map[string]bool {
+	keys := map[string]bool{}
+
+	for _, s := range d.Middleware {
+		for _, fn := range s.callableFns() {
+			keys[fn.key()] = true
+		}
+	}
+
+	return keys
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
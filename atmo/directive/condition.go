@@ -0,0 +1,115 @@
+package directive
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// condition is a parsed 'when' expression, a single comparison such as 'status == 200'
+type condition struct {
+	left     operand
+	operator string
+	right    operand
+}
+
+// operand is one side of a condition, either a reference to a state key or a literal value
+type operand struct {
+	value        string
+	isIdentifier bool
+}
+
+var conditionOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// identifierRegex matches a state key, optionally followed by a dotted path into its value
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_#\-]*(\.[A-Za-z0-9_\-]+)*$`)
+
+// parseCondition parses a 'when' expression in the form '<operand> <operator> <operand>'
+func parseCondition(expr string) (*condition, error) {
+	opIndex, operator := -1, ""
+
+	// find the first operator that isn't inside of a quoted string
+	quote := rune(0)
+	for i, char := range expr {
+		if quote != 0 {
+			if char == quote {
+				quote = 0
+			}
+
+			continue
+		} else if char == '"' || char == '\'' {
+			quote = char
+			continue
+		}
+
+		for _, op := range conditionOperators {
+			if strings.HasPrefix(expr[i:], op) {
+				opIndex, operator = i, op
+				break
+			}
+		}
+
+		if opIndex != -1 {
+			break
+		}
+	}
+
+	if opIndex == -1 {
+		return nil, fmt.Errorf("condition %q does not contain a comparison operator", expr)
+	}
+
+	left, err := parseOperand(expr[:opIndex])
+	if err != nil {
+		return nil, fmt.Errorf("condition %q is invalid: %s", expr, err.Error())
+	}
+
+	right, err := parseOperand(expr[opIndex+len(operator):])
+	if err != nil {
+		return nil, fmt.Errorf("condition %q is invalid: %s", expr, err.Error())
+	}
+
+	cond := &condition{
+		left:     left,
+		operator: operator,
+		right:    right,
+	}
+
+	return cond, nil
+}
+
+func parseOperand(raw string) (operand, error) {
+	value := strings.TrimSpace(raw)
+
+	switch {
+	case value == "":
+		return operand{}, fmt.Errorf("missing operand")
+	case value == "true" || value == "false" || value == "null":
+		return operand{value: value}, nil
+	case len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0]:
+		return operand{value: value[1 : len(value)-1]}, nil
+	}
+
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return operand{value: value}, nil
+	}
+
+	if !identifierRegex.MatchString(value) {
+		return operand{}, fmt.Errorf("operand %s is not a state key or a literal value", value)
+	}
+
+	return operand{value: value, isIdentifier: true}, nil
+}
+
+// stateKeys returns the state keys that the condition references
+func (c *condition) stateKeys() []string {
+	keys := []string{}
+
+	for _, o := range []operand{c.left, c.right} {
+		if o.isIdentifier {
+			keys = append(keys, strings.SplitN(o.value, ".", 2)[0])
+		}
+	}
+
+	return keys
+}
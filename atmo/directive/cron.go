@@ -0,0 +1,165 @@
+package directive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression (minute, hour, day of month, month, day of week)
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// if both day fields are restricted, a day matches when either of them does (as in standard cron)
+	domRestricted, dowRestricted bool
+}
+
+// cronField describes the allowed range of a single cron field
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// parseCron parses a standard five-field cron expression such as "0 9 * * 1-5"
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields, found %d", expr, len(cronFields), len(fields))
+	}
+
+	bits := make([]uint64, len(fields))
+
+	for i, field := range fields {
+		parsed, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+
+		bits[i] = parsed
+	}
+
+	// 7 is an alias for Sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1 << 0
+	}
+
+	cron := &cronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dom:           bits[2],
+		month:         bits[3],
+		dow:           bits[4],
+		domRestricted: !strings.HasPrefix(fields[2], "*"),
+		dowRestricted: !strings.HasPrefix(fields[4], "*"),
+	}
+
+	return cron, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps into a bitset
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+
+		if slashIndex := strings.Index(part, "/"); slashIndex != -1 {
+			parsedStep, err := strconv.Atoi(part[slashIndex+1:])
+			if err != nil || parsedStep < 1 {
+				return 0, fmt.Errorf("cron %s field has invalid step in %q", spec.name, part)
+			}
+
+			rangePart, step = part[:slashIndex], parsedStep
+		}
+
+		start, end := spec.min, spec.max
+
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+
+			parsedStart, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("cron %s field has invalid value %q", spec.name, part)
+			}
+
+			start, end = parsedStart, parsedStart
+
+			if len(bounds) == 2 {
+				parsedEnd, err := strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("cron %s field has invalid value %q", spec.name, part)
+				}
+
+				end = parsedEnd
+			} else if step > 1 {
+				// a single value with a step (i.e. 5/15) runs from the value to the end of the range
+				end = spec.max
+			}
+		}
+
+		if start < spec.min || end > spec.max || start > end {
+			return 0, fmt.Errorf("cron %s field value %q is outside of the range %d-%d", spec.name, part, spec.min, spec.max)
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+// next returns the first time strictly after 'from' that matches the cron schedule
+func (c *cronSchedule) next(from time.Time) (time.Time, error) {
+	loc := from.Location()
+	t := time.Date(from.Year(), from.Month(), from.Day(), from.Hour(), from.Minute()+1, 0, 0, loc)
+
+	// every valid expression matches at least once within a leap year cycle
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("cron schedule has no run time within 5 years of %s", from)
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
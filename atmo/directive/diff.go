@@ -0,0 +1,90 @@
+package directive
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// DirectiveDiff describes what changed between two versions of a Directive. Runnables are identified
+// by their namespaced name, handlers by their input and version (i.e. "GET /users" or "GET /users@v2.0.0"),
+// and schedules by their name
+type DirectiveDiff struct {
+	AddedRunnables   []string
+	RemovedRunnables []string
+	ChangedRunnables []string
+
+	AddedHandlers   []string
+	RemovedHandlers []string
+	ChangedHandlers []string
+
+	AddedSchedules   []string
+	RemovedSchedules []string
+	ChangedSchedules []string
+}
+
+// Diff returns the changes needed to go from the Directive to other
+func (d *Directive) Diff(other *Directive) DirectiveDiff {
+	diff := DirectiveDiff{}
+
+	oldRunnables, newRunnables := map[string]interface{}{}, map[string]interface{}{}
+	for _, r := range d.Runnables {
+		oldRunnables[fmt.Sprintf("%s#%s", d.runnableNamespace(r), r.Name)] = r
+	}
+
+	for _, r := range other.Runnables {
+		newRunnables[fmt.Sprintf("%s#%s", other.runnableNamespace(r), r.Name)] = r
+	}
+
+	diff.AddedRunnables, diff.RemovedRunnables, diff.ChangedRunnables = diffElements(oldRunnables, newRunnables)
+
+	oldHandlers, newHandlers := map[string]interface{}{}, map[string]interface{}{}
+	for _, h := range d.Handlers {
+		oldHandlers[h.key()] = h
+	}
+
+	for _, h := range other.Handlers {
+		newHandlers[h.key()] = h
+	}
+
+	diff.AddedHandlers, diff.RemovedHandlers, diff.ChangedHandlers = diffElements(oldHandlers, newHandlers)
+
+	oldSchedules, newSchedules := map[string]interface{}{}, map[string]interface{}{}
+	for _, s := range d.Schedules {
+		oldSchedules[s.Name] = s
+	}
+
+	for _, s := range other.Schedules {
+		newSchedules[s.Name] = s
+	}
+
+	diff.AddedSchedules, diff.RemovedSchedules, diff.ChangedSchedules = diffElements(oldSchedules, newSchedules)
+
+	return diff
+}
+
+// diffElements compares two sets of named elements and returns the sorted names of those added, removed, and changed
+func diffElements(old, new map[string]interface{}) (added, removed, changed []string) {
+	added, removed, changed = []string{}, []string{}, []string{}
+
+	for name, oldElem := range old {
+		newElem, exists := new[name]
+		if !exists {
+			removed = append(removed, name)
+		} else if !reflect.DeepEqual(oldElem, newElem) {
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range new {
+		if _, exists := old[name]; !exists {
+			added = append(added, name)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	return added, removed, changed
+}
@@ -0,0 +1,3020 @@
+package directive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// InputTypeRequest and others represent consts for Directives
+const (
+	InputTypeRequest = "request"
+	InputTypeStream  = "stream"
+	InputTypeEvent   = "event"
+)
+
+// ProblemKindDirective and others represent the kinds of element a ValidationProblem can refer to
+const (
+	ProblemKindDirective  = "directive"
+	ProblemKindRunnable   = "runnable"
+	ProblemKindHandler    = "handler"
+	ProblemKindSchedule   = "schedule"
+	ProblemKindMiddleware = "middleware"
+)
+
+// MaxFnTimeout is the largest 'timeout' value (in seconds) that a fn can specify
+var MaxFnTimeout = 300
+
+// DefaultMaxStepFns is the largest number of fns (including group members) that a handler or schedule can call
+// unless ValidateOptions sets its own, which bounds the work done validating a hostile directive
+const DefaultMaxStepFns = 1024
+
+// DefaultMaxDepth is the deepest that a handler or schedule's steps can be nested unless ValidateOptions sets its own.
+// A single fn is one level deep, and a group or ForEach is two since it contains fns
+const DefaultMaxDepth = 8
+
+// MaxDescriptionLength is the longest 'description' value that a runnable, handler, or schedule can have
+var MaxDescriptionLength = 1024
+
+// NamespaceDefault and others represent conts for namespaces
+const (
+	NamespaceDefault = "default"
+)
+
+// Directive describes a set of functions and a set of handlers
+// that take an input, and compose a set of functions to handle it
+type Directive struct {
+	Identifier  string     `yaml:"identifier" json:"identifier"`
+	AppVersion  string     `yaml:"appVersion" json:"appVersion"`
+	AtmoVersion string     `yaml:"atmoVersion" json:"atmoVersion"`
+	Runnables   []Runnable `yaml:"runnables" json:"runnables"`
+	Handlers    []Handler  `yaml:"handlers,omitempty" json:"handlers,omitempty"`
+	Schedules   []Schedule `yaml:"schedules,omitempty" json:"schedules,omitempty"`
+
+	// Middleware are steps that run before the steps of every handler
+	Middleware []Executable `yaml:"middleware,omitempty" json:"middleware,omitempty"`
+
+	// DefaultNamespace is given to any runnable that does not specify its own namespace
+	DefaultNamespace string `yaml:"defaultNamespace,omitempty" json:"defaultNamespace,omitempty"`
+
+	// Defaults are applied to every step that doesn't set its own values
+	Defaults *Defaults `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+
+	// Secrets are the names of the secrets that steps can reference with a 'secret:NAME' value in 'with',
+	// their values are resolved at runtime rather than living in the directive
+	Secrets []string `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+
+	// "fully qualified function names"
+	fqfns map[string]string `yaml:"-"`
+
+	// fqfnPrefix is prepended to every FQFN, i.e. for a registry
+	fqfnPrefix string `yaml:"-"`
+
+	// warnings are the messages of the warnings found by the most recent validation
+	warnings []string `yaml:"-"`
+
+	// positions are the lines of the Directive's elements in the YAML it was unmarshalled from, if any
+	positions *sourcePositions `yaml:"-"`
+}
+
+// Handler represents the mapping between an input and a composition of functions
+type Handler struct {
+	Input    `yaml:"input,inline"`
+	State    map[string]string `yaml:"state,omitempty" json:"state,omitempty"`
+	Steps    []Executable      `yaml:"steps" json:"steps"`
+	Response string            `yaml:"response,omitempty" json:"response,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+
+	// Description is a human-readable explanation of the handler, i.e. for API docs
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	// Version is the version of the API that the handler serves, so that handlers for the same input can coexist
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+
+	// Enabled can be set to false to turn the handler off without removing it, it is enabled if unset
+	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+
+	// Internal marks a handler that is only invoked by other handlers or schedules, and should not be routed externally
+	Internal bool `yaml:"internal,omitempty" json:"internal,omitempty"`
+}
+
+// Schedule represents the mapping between an input and a composition of functions
+type Schedule struct {
+	Name  string            `yaml:"name" json:"name"`
+	Every ScheduleEvery     `yaml:"every,omitempty" json:"every,omitempty"`
+	Cron  string            `yaml:"cron,omitempty" json:"cron,omitempty"`
+	State map[string]string `yaml:"state,omitempty" json:"state,omitempty"`
+	Steps []Executable      `yaml:"steps" json:"steps"`
+
+	// Description is a human-readable explanation of the schedule
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	// Enabled can be set to false to turn the schedule off without removing it, it is enabled if unset
+	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+}
+
+// ScheduleEvery represents the 'every' value for a schedule
+type ScheduleEvery struct {
+	Seconds int `yaml:"seconds,omitempty" json:"seconds,omitempty"`
+	Minutes int `yaml:"minutes,omitempty" json:"minutes,omitempty"`
+	Hours   int `yaml:"hours,omitempty" json:"hours,omitempty"`
+	Days    int `yaml:"days,omitempty" json:"days,omitempty"`
+}
+
+// Input represents an input source
+type Input struct {
+	Type     string `yaml:"type" json:"type"`
+	Method   string `yaml:"method" json:"method"`
+	Resource string `yaml:"resource" json:"resource"`
+}
+
+// name returns the name used to refer to a handler for the input, i.e. "GET /users" or "stream users"
+func (i Input) name() string {
+	if i.Type == InputTypeRequest || i.Type == "" {
+		return fmt.Sprintf("%s %s", i.Method, i.Resource)
+	}
+
+	return fmt.Sprintf("%s %s", i.Type, i.Resource)
+}
+
+// key identifies the handler among the Directive's handlers, i.e. "GET /users" or "GET /users@v2.0.0"
+func (h Handler) key() string {
+	if h.Version != "" {
+		return fmt.Sprintf("%s@%s", h.Input.name(), h.Version)
+	}
+
+	return h.Input.name()
+}
+
+// normalizedKey is the key of the handler once its input is normalized, so that handlers added without
+// being normalized (i.e. with AddHandler) are still found to be duplicates of equivalent ones
+func (h Handler) normalizedKey() string {
+	h.Input.Normalize()
+
+	return h.key()
+}
+
+// Normalize converts the Input's values to their canonical form. For requests, the resource is given a leading
+// slash, any repeated slashes are collapsed, and a trailing slash is removed, so 'users', '//users', and '/users/'
+// all become '/users'. Stream resources
+// aren't paths (they are usually topic names), so they are left as they are
+func (i *Input) Normalize() {
+	i.Method = strings.ToUpper(i.Method)
+
+	if (i.Type == InputTypeRequest || i.Type == "") && i.Resource != "" {
+		i.Resource = normalizeResource(i.Resource)
+	}
+}
+
+// normalizeResource returns the canonical form of a resource path
+func normalizeResource(resource string) string {
+	resource = "/" + resource
+
+	for strings.Contains(resource, "//") {
+		resource = strings.ReplaceAll(resource, "//", "/")
+	}
+
+	if len(resource) > 1 {
+		resource = strings.TrimSuffix(resource, "/")
+	}
+
+	return resource
+}
+
+// Match returns true if the Input handles the given method (case-insensitive) and concrete path, along with
+// the values of any path params, i.e. '/users/42' matches the resource '/users/:id' with the params {"id": "42"}
+func (i Input) Match(method, path string) (map[string]string, bool) {
+	if !strings.EqualFold(i.Method, method) {
+		return nil, false
+	}
+
+	resourceSegments := strings.Split(strings.Trim(i.Resource, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(resourceSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+
+	for j, segment := range resourceSegments {
+		if strings.HasPrefix(segment, ":") && len(segment) > 1 {
+			// a param must match a non-empty segment
+			if pathSegments[j] == "" {
+				return nil, false
+			}
+
+			params[segment[1:]] = pathSegments[j]
+		} else if segment != pathSegments[j] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// httpMethods is the set of HTTP verbs a request Input can use
+var httpMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+}
+
+// ParseInput parses a handler name in the "METHOD resource" format into a request Input
+func ParseInput(s string) (Input, error) {
+	parts := strings.SplitN(s, " ", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Input{}, fmt.Errorf("input %s is missing a resource", s)
+	}
+
+	method, resource := strings.ToUpper(parts[0]), parts[1]
+
+	if _, known := httpMethods[method]; !known {
+		return Input{}, fmt.Errorf("input %s has unknown method %s", s, method)
+	}
+
+	input := Input{
+		Type:     InputTypeRequest,
+		Method:   method,
+		Resource: resource,
+	}
+
+	return input, nil
+}
+
+// Executable represents an executable step in a handler
+type Executable struct {
+	CallableFn `yaml:"callableFn,inline"`
+	Group      []CallableFn `yaml:"group,omitempty" json:"group,omitempty"`
+	ForEach    *ForEach     `yaml:"forEach,omitempty" json:"forEach,omitempty"`
+
+	// Response, if set, ends the workflow once the step completes and responds with the given state key
+	Response string `yaml:"response,omitempty" json:"response,omitempty"`
+}
+
+// CallableFn is a fn along with its "variable name" and "args"
+type CallableFn struct {
+	Fn    string   `yaml:"fn,omitempty" json:"fn,omitempty"`
+	As    string   `yaml:"as,omitempty" json:"as,omitempty"`
+	With  FnWith   `yaml:"with,omitempty" json:"with,omitempty"`
+	OnErr *FnOnErr `yaml:"onErr,omitempty" json:"onErr,omitempty"`
+
+	// Timeout is the number of seconds the fn may run for, 0 uses the default
+	Timeout int `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// When is a condition (such as 'status == 200') that must be true for the fn to run
+	When string `yaml:"when,omitempty" json:"when,omitempty"`
+
+	// Args are constant arguments passed to the fn alongside those taken from the state by 'with'
+	Args map[string]string `yaml:"args,omitempty" json:"args,omitempty"`
+}
+
+// FnWith maps the names of a fn's arguments to the state keys they are taken from.
+// It can be written as a map (alias: key), or as a list of 'alias: key' or bare 'key' entries,
+// where an unquoted 'alias: key' entry in YAML (a single-pair map) is accepted too
+type FnWith map[string]string
+
+// Alias is a single 'with' entry, passing the state key Key to a fn as the argument Alias
+type Alias struct {
+	Key   string
+	Alias string
+}
+
+// aliasRegex matches a valid 'with' alias, which becomes the name of the fn's argument
+var aliasRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_\-]*$`)
+
+// ParseWith parses a list of 'with' entries in the 'alias: key' format,
+// a bare entry (i.e. 'user') uses the state key as its alias. A key can reference a secret (i.e. 'key: secret:apiKey'),
+// and a bare secret reference (i.e. 'secret:apiKey') uses the secret's name as its alias. The input is never modified
+// and a new slice is always returned, so entries shared via YAML anchors are parsed independently
+func ParseWith(with []string) ([]Alias, error) {
+	aliases := make([]Alias, len(with))
+
+	for i, w := range with {
+		alias, err := parseWithEntry(w)
+		if err != nil {
+			return nil, err
+		}
+
+		aliases[i] = alias
+	}
+
+	return aliases, nil
+}
+
+// parseWithEntry parses a single 'with' entry
+func parseWithEntry(w string) (Alias, error) {
+	entry := strings.TrimSpace(w)
+	alias, key := "", entry
+	bare := true
+
+	// the colon of a bare secret reference separates its prefix from its name, not an alias from a key
+	if !strings.HasPrefix(entry, withSecretPrefix) {
+		if parts := strings.SplitN(entry, ":", 2); len(parts) == 2 {
+			alias, key = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			bare = false
+		}
+	}
+
+	name := key
+	if strings.HasPrefix(key, withSecretPrefix) {
+		name = strings.TrimSpace(strings.TrimPrefix(key, withSecretPrefix))
+		key = withSecretPrefix + name
+	}
+
+	if name == "" || strings.Contains(name, ":") || (!bare && alias == "") {
+		return Alias{}, fmt.Errorf("with value %q is not in the 'alias: key' format", w)
+	}
+
+	if bare {
+		return Alias{Key: key, Alias: name}, nil
+	}
+
+	if !aliasRegex.MatchString(alias) {
+		return Alias{}, fmt.Errorf("with value %q has alias %q, which is not a valid identifier", w, alias)
+	}
+
+	return Alias{Key: key, Alias: alias}, nil
+}
+
+// WithEntries renders aliases as 'with' entries in the 'alias: key' format, the inverse of ParseWith.
+// Entries whose alias is the same as their key (or the name of the secret it references) are rendered in the bare form
+func WithEntries(aliases []Alias) []string {
+	entries := make([]string, len(aliases))
+
+	for i, a := range aliases {
+		if a.Alias == a.Key || a.Key == withSecretPrefix+a.Alias {
+			entries[i] = a.Key
+		} else {
+			entries[i] = fmt.Sprintf("%s: %s", a.Alias, a.Key)
+		}
+	}
+
+	return entries
+}
+
+// SetWith replaces the fn's 'with' value with the given aliases
+func (c *CallableFn) SetWith(aliases []Alias) {
+	c.With = make(FnWith, len(aliases))
+
+	for _, a := range aliases {
+		c.With[a.Alias] = a.Key
+	}
+}
+
+// Aliases returns the 'with' entries sorted by their alias
+func (w FnWith) Aliases() []Alias {
+	aliases := make([]Alias, 0, len(w))
+
+	for alias, key := range w {
+		aliases = append(aliases, Alias{Key: key, Alias: alias})
+	}
+
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+
+	return aliases
+}
+
+// String returns a compact representation of the fn for logging, i.e. 'auth#verify(token: authToken) as user'
+func (c CallableFn) String() string {
+	str := c.Fn
+
+	if len(c.With) > 0 {
+		str += fmt.Sprintf("(%s)", strings.Join(WithEntries(c.With.Aliases()), ", "))
+	}
+
+	if c.As != "" {
+		str += fmt.Sprintf(" as %s", c.As)
+	}
+
+	return str
+}
+
+// UnmarshalYAML unmarshals either the map or the list form of a 'with' value
+func (w *FnWith) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	withMap := map[string]string{}
+	if err := unmarshal(&withMap); err == nil {
+		*w = withMap
+		return nil
+	}
+
+	rawList := []interface{}{}
+	if err := unmarshal(&rawList); err != nil {
+		return errors.New("'with' value must be a map or a list of 'alias: key' entries")
+	}
+
+	withList := make([]string, len(rawList))
+	for i, raw := range rawList {
+		switch entry := raw.(type) {
+		case string:
+			withList[i] = entry
+		case map[interface{}]interface{}:
+			// an unquoted 'alias: key' entry is decoded as a map with a single pair
+			if len(entry) != 1 {
+				return fmt.Errorf("'with' entry at position %d must be a single 'alias: key' pair", i)
+			}
+
+			for alias, key := range entry {
+				aliasStr, aliasOK := alias.(string)
+				keyStr, keyOK := key.(string)
+				if !aliasOK || !keyOK {
+					return fmt.Errorf("'with' entry at position %d must be a single 'alias: key' pair", i)
+				}
+
+				withList[i] = fmt.Sprintf("%s: %s", aliasStr, keyStr)
+			}
+		default:
+			return errors.New("'with' value must be a map or a list of 'alias: key' entries")
+		}
+	}
+
+	return w.setFromList(withList)
+}
+
+// UnmarshalJSON unmarshals either the map or the list form of a 'with' value
+func (w *FnWith) UnmarshalJSON(in []byte) error {
+	withMap := map[string]string{}
+	if err := json.Unmarshal(in, &withMap); err == nil {
+		*w = withMap
+		return nil
+	}
+
+	withList := []string{}
+	if err := json.Unmarshal(in, &withList); err != nil {
+		return errors.New("'with' value must be an object or a list of 'alias: key' entries")
+	}
+
+	return w.setFromList(withList)
+}
+
+func (w *FnWith) setFromList(withList []string) error {
+	aliases, err := ParseWith(withList)
+	if err != nil {
+		return err
+	}
+
+	withMap := make(FnWith, len(aliases))
+	for _, a := range aliases {
+		// the map form can't hold the same alias twice, so a duplicate in the list would silently replace the first
+		if _, exists := withMap[a.Alias]; exists {
+			return fmt.Errorf("'with' alias %s is used more than once", a.Alias)
+		}
+
+		withMap[a.Alias] = a.Key
+	}
+
+	*w = withMap
+
+	return nil
+}
+
+// FnOnErr describes how to handle an error from a function call
+type FnOnErr struct {
+	Code  map[int]string `yaml:"code,omitempty" json:"code,omitempty"`
+	Any   string         `yaml:"any,omitempty" json:"any,omitempty"`
+	Other string         `yaml:"other,omitempty" json:"other,omitempty"`
+
+	// Retries and BackoffMs configure the 'retry' error directive
+	Retries   int `yaml:"retries,omitempty" json:"retries,omitempty"`
+	BackoffMs int `yaml:"backoffMs,omitempty" json:"backoffMs,omitempty"`
+}
+
+// Defaults are directive-wide values for the steps of every handler, schedule, and middleware
+type Defaults struct {
+	// Retries and BackoffMs are the retry policy of every step, including steps without an onErr,
+	// except where a step's onErr sets its own values
+	Retries   int `yaml:"retries,omitempty" json:"retries,omitempty"`
+	BackoffMs int `yaml:"backoffMs,omitempty" json:"backoffMs,omitempty"`
+}
+
+// inherit returns a copy of onErr with any unset retry values taken from the defaults
+func (d *Defaults) inherit(onErr *FnOnErr) *FnOnErr {
+	if d == nil || onErr == nil {
+		return onErr
+	}
+
+	c := onErr.copy()
+
+	if c.Retries == 0 {
+		c.Retries = d.Retries
+	}
+
+	if c.BackoffMs == 0 {
+		c.BackoffMs = d.BackoffMs
+	}
+
+	return c
+}
+
+// RetryPolicy returns the number of retries and the backoff (in milliseconds) for the fn, which are the fn's
+// own onErr values or, where those are unset (including when the fn has no onErr), the Directive's Defaults
+func (d *Directive) RetryPolicy(fn CallableFn) (retries, backoffMs int) {
+	onErr := d.Defaults.inherit(fn.OnErr)
+	if onErr == nil {
+		if d.Defaults == nil {
+			return 0, 0
+		}
+
+		return d.Defaults.Retries, d.Defaults.BackoffMs
+	}
+
+	return onErr.Retries, onErr.BackoffMs
+}
+
+// dnsLabelRegex matches a DNS label, which runnable names and namespaces must be since they end up in FQFNs and registry identifiers
+var dnsLabelRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// errDirectives is the set of valid values for handling a fn's error
+var errDirectives = map[string]bool{
+	"continue": true,
+	"return":   true,
+	"retry":    true,
+}
+
+// withSecretPrefix prefixes a 'with' value that references a declared secret rather than a state key, i.e. 'secret:apiKey'
+const withSecretPrefix = "secret:"
+
+// errDirectiveGoto prefixes an error directive that jumps to a later step by its 'as' name, i.e. 'goto:cleanup'
+const errDirectiveGoto = "goto:"
+
+// isErrDirective returns true if val is a valid error directive
+func isErrDirective(val string) bool {
+	if strings.HasPrefix(val, errDirectiveGoto) {
+		return strings.TrimPrefix(val, errDirectiveGoto) != ""
+	}
+
+	return errDirectives[val]
+}
+
+// minErrCode and maxErrCode bound the status codes that 'onErr.code' can map
+const (
+	minErrCode = 100
+	maxErrCode = 599
+)
+
+type ForEach struct {
+	In    string   `yaml:"in" json:"in"`
+	Fn    string   `yaml:"fn" json:"fn"`
+	As    string   `yaml:"as" json:"as"`
+	OnErr *FnOnErr `yaml:"onErr,omitempty" json:"onErr,omitempty"`
+
+	// Parallelism is a hint for how many iterations can run concurrently, 0 is the default and 1 is sequential
+	Parallelism int `yaml:"parallelism,omitempty" json:"parallelism,omitempty"`
+}
+
+// Marshal outputs the YAML bytes of the Directive
+func (d *Directive) Marshal() ([]byte, error) {
+	return yaml.Marshal(d)
+}
+
+// Unmarshal unmarshals YAML bytes into a Directive struct
+// it also calculates a map of FQFNs for later use, and records the source lines that validation problems refer to.
+// It returns an error if a handler, schedule, or middleware exceeds DefaultMaxDepth or DefaultMaxStepFns
+func (d *Directive) Unmarshal(in []byte) error {
+	if err := yaml.Unmarshal(in, d); err != nil {
+		return err
+	}
+
+	d.initialize()
+	d.recordPositions(in)
+
+	return d.checkLimits()
+}
+
+// UnmarshalStrict is like Unmarshal, but returns an error if the YAML contains
+// unknown or duplicate keys, such as a misspelled 'runables'
+func (d *Directive) UnmarshalStrict(in []byte) error {
+	if err := yaml.UnmarshalStrict(in, d); err != nil {
+		return err
+	}
+
+	d.initialize()
+	d.recordPositions(in)
+
+	return d.checkLimits()
+}
+
+// Decode reads a single YAML document from r into a Directive struct, calculating its FQFNs like Unmarshal,
+// without reading the whole of r into memory first. It returns io.EOF if r contains no document.
+// Since the source isn't kept, the problems found by validating a decoded Directive have no Line
+func (d *Directive) Decode(r io.Reader) error {
+	if err := yaml.NewDecoder(r).Decode(d); err != nil {
+		return err
+	}
+
+	d.initialize()
+
+	return d.checkLimits()
+}
+
+// directiveJSON is used to (un)marshal a Directive as JSON without recursing into its JSON methods
+type directiveJSON Directive
+
+// UnmarshalAll unmarshals every document in a multi-document YAML stream (separated by '---') into a Directive,
+// calculating the FQFNs and source lines of each. Empty documents are skipped, and the error for a malformed document
+// identifies its index in the stream, starting at 0
+func UnmarshalAll(in []byte) ([]*Directive, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(in))
+	directives := []*Directive{}
+
+	// the documents are decoded a second time as yaml.v3 nodes, which expose their lines
+	nodeDecoder := yaml3.NewDecoder(bytes.NewReader(in))
+	nodesOK := true
+
+	for i := 0; ; i++ {
+		d := &Directive{}
+
+		if err := decoder.Decode(d); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal document %d: %w", i, err)
+		}
+
+		doc := &yaml3.Node{}
+		if nodesOK {
+			nodesOK = nodeDecoder.Decode(doc) == nil
+		}
+
+		// i.e. a trailing '---'
+		if reflect.DeepEqual(*d, Directive{}) {
+			continue
+		}
+
+		d.initialize()
+
+		if err := d.checkLimits(); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal document %d: %w", i, err)
+		}
+
+		if nodesOK {
+			d.positions = positionsOf(doc, d)
+		}
+
+		directives = append(directives, d)
+	}
+
+	return directives, nil
+}
+
+// MarshalJSON outputs the JSON bytes of the Directive
+func (d *Directive) MarshalJSON() ([]byte, error) {
+	return json.Marshal((*directiveJSON)(d))
+}
+
+// UnmarshalJSON unmarshals JSON bytes into a Directive struct
+// it also calculates a map of FQFNs for later use
+func (d *Directive) UnmarshalJSON(in []byte) error {
+	if err := json.Unmarshal(in, (*directiveJSON)(d)); err != nil {
+		return err
+	}
+
+	d.initialize()
+
+	return d.checkLimits()
+}
+
+// MarshalCompact outputs the Directive as minified JSON on a single line, i.e. for embedding in an environment variable
+func (d *Directive) MarshalCompact() ([]byte, error) {
+	return d.MarshalJSON()
+}
+
+// UnmarshalCompact unmarshals the output of MarshalCompact into a Directive struct. Since JSON is
+// also valid YAML, Unmarshal accepts the compact form as well
+func (d *Directive) UnmarshalCompact(in []byte) error {
+	return d.UnmarshalJSON(in)
+}
+
+// initialize prepares a newly unmarshalled Directive for use
+func (d *Directive) initialize() {
+	d.Normalize()
+	d.calculateFQFNs()
+}
+
+// Normalize converts the Directive's values to their canonical form, such as uppercasing HTTP methods,
+// adding the leading slash to resources, and giving runnables without a namespace the directive's defaultNamespace
+func (d *Directive) Normalize() {
+	if d.DefaultNamespace != "" {
+		for i := range d.Runnables {
+			if d.Runnables[i].Namespace == "" {
+				d.Runnables[i].Namespace = d.DefaultNamespace
+			}
+		}
+	}
+
+	for i := range d.Handlers {
+		d.Handlers[i].Input.Normalize()
+	}
+}
+
+// Hash returns a SHA-256 hash of the Directive's contents that is independent of its YAML formatting,
+// so directives that differ only in comments or key order have the same hash
+func (d *Directive) Hash() (string, error) {
+	// encoding/json outputs struct fields in a fixed order and sorts map keys, which makes it a canonical form
+	canonical, err := json.Marshal((*directiveJSON)(d))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Copy returns a deep copy of the Directive that shares no state with the original
+func (d *Directive) Copy() *Directive {
+	c := *d
+
+	if d.Runnables != nil {
+		c.Runnables = make([]Runnable, len(d.Runnables))
+		copy(c.Runnables, d.Runnables)
+	}
+
+	if d.Handlers != nil {
+		c.Handlers = make([]Handler, len(d.Handlers))
+		for i, h := range d.Handlers {
+			h.State = copyStringMap(h.State)
+			h.Headers = copyStringMap(h.Headers)
+			h.Steps = copySteps(h.Steps)
+			h.Enabled = copyBool(h.Enabled)
+			c.Handlers[i] = h
+		}
+	}
+
+	c.Middleware = copySteps(d.Middleware)
+
+	if d.Defaults != nil {
+		defaults := *d.Defaults
+		c.Defaults = &defaults
+	}
+
+	if d.Secrets != nil {
+		c.Secrets = make([]string, len(d.Secrets))
+		copy(c.Secrets, d.Secrets)
+	}
+
+	if d.Schedules != nil {
+		c.Schedules = make([]Schedule, len(d.Schedules))
+		for i, s := range d.Schedules {
+			s.State = copyStringMap(s.State)
+			s.Steps = copySteps(s.Steps)
+			s.Enabled = copyBool(s.Enabled)
+			c.Schedules[i] = s
+		}
+	}
+
+	c.fqfns = copyStringMap(d.fqfns)
+
+	if d.warnings != nil {
+		c.warnings = make([]string, len(d.warnings))
+		copy(c.warnings, d.warnings)
+	}
+
+	return &c
+}
+
+func copyBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+
+	c := *b
+
+	return &c
+}
+
+func copySteps(steps []Executable) []Executable {
+	if steps == nil {
+		return nil
+	}
+
+	c := make([]Executable, len(steps))
+	for i, s := range steps {
+		s.CallableFn = s.CallableFn.copy()
+
+		if s.Group != nil {
+			group := make([]CallableFn, len(s.Group))
+			for j, fn := range s.Group {
+				group[j] = fn.copy()
+			}
+
+			s.Group = group
+		}
+
+		if s.ForEach != nil {
+			forEach := *s.ForEach
+			forEach.OnErr = s.ForEach.OnErr.copy()
+			s.ForEach = &forEach
+		}
+
+		c[i] = s
+	}
+
+	return c
+}
+
+func (c CallableFn) copy() CallableFn {
+	c.With = copyStringMap(c.With)
+	c.Args = copyStringMap(c.Args)
+	c.OnErr = c.OnErr.copy()
+
+	return c
+}
+
+// onErrYAML is the YAML form of FnOnErr, with 'code' keys that can be strings
+type onErrYAML struct {
+	Code      map[string]string `yaml:"code,omitempty"`
+	Any       string            `yaml:"any,omitempty"`
+	Other     string            `yaml:"other,omitempty"`
+	Retries   int               `yaml:"retries,omitempty"`
+	BackoffMs int               `yaml:"backoffMs,omitempty"`
+}
+
+// UnmarshalYAML unmarshals an onErr value, accepting 'code' keys that are strings as well as ints,
+// since JSON (such as the output of MarshalCompact) can only have string keys
+func (f *FnOnErr) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	raw := onErrYAML{}
+
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*f = FnOnErr{Any: raw.Any, Other: raw.Other, Retries: raw.Retries, BackoffMs: raw.BackoffMs}
+
+	if raw.Code != nil {
+		f.Code = make(map[int]string, len(raw.Code))
+		for key, val := range raw.Code {
+			code, err := strconv.Atoi(key)
+			if err != nil {
+				return fmt.Errorf("'onErr.code' key %q is not a status code", key)
+			}
+
+			f.Code[code] = val
+		}
+	}
+
+	return nil
+}
+
+func (f *FnOnErr) copy() *FnOnErr {
+	if f == nil {
+		return nil
+	}
+
+	c := *f
+
+	if f.Code != nil {
+		c.Code = make(map[int]string, len(f.Code))
+		for code, val := range f.Code {
+			c.Code[code] = val
+		}
+	}
+
+	return &c
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// AddRunnable adds a runnable to the Directive, it is not validated until Validate is called
+func (d *Directive) AddRunnable(namespace, name string) *Directive {
+	d.Runnables = append(d.Runnables, Runnable{Name: name, Namespace: namespace})
+
+	// recalculate on the next call to FQFN
+	d.fqfns = nil
+
+	return d
+}
+
+// ReplaceRunnable replaces the runnable with the same namespace and name as r, i.e. after it has been rebuilt
+func (d *Directive) ReplaceRunnable(r Runnable) error {
+	for i, existing := range d.Runnables {
+		if d.runnableNamespace(existing) == d.runnableNamespace(r) && existing.Name == r.Name {
+			d.Runnables[i] = r
+
+			// recalculate on the next call to FQFN
+			d.fqfns = nil
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("fn %s#%s does not exist", d.runnableNamespace(r), r.Name)
+}
+
+// AddHandler adds a handler to the Directive, it is not validated until Validate is called
+func (d *Directive) AddHandler(h Handler) *Directive {
+	d.Handlers = append(d.Handlers, h)
+	d.fqfns = nil
+
+	return d
+}
+
+// AddSchedule adds a schedule to the Directive, it is not validated until Validate is called
+func (d *Directive) AddSchedule(s Schedule) *Directive {
+	d.Schedules = append(d.Schedules, s)
+	d.fqfns = nil
+
+	return d
+}
+
+// Sort stably sorts the Directive's runnables by their namespaced name, handlers by their input (and version),
+// and schedules by their name, so that marshalling the Directive has a canonical output
+func (d *Directive) Sort() {
+	sort.SliceStable(d.Runnables, func(i, j int) bool {
+		return fmt.Sprintf("%s#%s", d.runnableNamespace(d.Runnables[i]), d.Runnables[i].Name) < fmt.Sprintf("%s#%s", d.runnableNamespace(d.Runnables[j]), d.Runnables[j].Name)
+	})
+
+	sort.SliceStable(d.Handlers, func(i, j int) bool {
+		return d.Handlers[i].key() < d.Handlers[j].key()
+	})
+
+	sort.SliceStable(d.Schedules, func(i, j int) bool {
+		return d.Schedules[i].Name < d.Schedules[j].Name
+	})
+
+	d.calculateFQFNs()
+}
+
+// Merge adds the runnables, handlers, schedules, and middleware from another (partial) Directive to the Directive,
+// with other's middleware running after the Directive's own, and combines their secrets. The two must not have
+// conflicting identifiers, versions, defaultNamespaces, or defaults, or runnables with the same namespaced name
+func (d *Directive) Merge(other *Directive) error {
+	merged := *d
+
+	var err error
+	if merged.Identifier, err = mergeField("identifier", d.Identifier, other.Identifier); err != nil {
+		return err
+	}
+
+	if merged.AppVersion, err = mergeField("appVersion", d.AppVersion, other.AppVersion); err != nil {
+		return err
+	}
+
+	if merged.AtmoVersion, err = mergeField("atmoVersion", d.AtmoVersion, other.AtmoVersion); err != nil {
+		return err
+	}
+
+	if merged.DefaultNamespace, err = mergeField("defaultNamespace", d.DefaultNamespace, other.DefaultNamespace); err != nil {
+		return err
+	}
+
+	if d.Defaults == nil {
+		merged.Defaults = other.Defaults
+	} else if other.Defaults != nil && *other.Defaults != *d.Defaults {
+		return errors.New("failed to merge, conflicting defaults values")
+	}
+
+	runnables := map[string]bool{}
+	for _, r := range d.Runnables {
+		runnables[fmt.Sprintf("%s#%s", d.runnableNamespace(r), r.Name)] = true
+	}
+
+	for _, r := range other.Runnables {
+		namespaced := fmt.Sprintf("%s#%s", other.runnableNamespace(r), r.Name)
+
+		if _, exists := runnables[namespaced]; exists {
+			return fmt.Errorf("failed to merge, duplicate fn %s found", namespaced)
+		}
+
+		runnables[namespaced] = true
+	}
+
+	merged.Runnables = append(d.Runnables, other.Runnables...)
+	merged.Handlers = append(d.Handlers, other.Handlers...)
+	merged.Schedules = append(d.Schedules, other.Schedules...)
+	merged.Middleware = append(d.Middleware, other.Middleware...)
+
+	// both fragments can declare a secret that their steps use
+	secrets := map[string]bool{}
+	for _, secret := range d.Secrets {
+		secrets[secret] = true
+	}
+
+	for _, secret := range other.Secrets {
+		if !secrets[secret] {
+			merged.Secrets = append(merged.Secrets, secret)
+			secrets[secret] = true
+		}
+	}
+
+	*d = merged
+	d.calculateFQFNs()
+
+	return nil
+}
+
+// mergeField returns whichever of the two values is set, or an error if they are both set and differ
+func mergeField(field, value, other string) (string, error) {
+	if value == "" {
+		return other, nil
+	} else if other != "" && other != value {
+		return "", fmt.Errorf("failed to merge, conflicting %s values %s and %s", field, value, other)
+	}
+
+	return value, nil
+}
+
+// FQFN returns the FQFN for a given function in the directive
+func (d *Directive) FQFN(fn string) (string, error) {
+	if d.fqfns == nil {
+		d.calculateFQFNs()
+	}
+
+	fqfn, exists := d.fqfns[fn]
+	if !exists {
+		return "", fmt.Errorf("fn %s does not exist", fn)
+	}
+
+	return fqfn, nil
+}
+
+// SeverityError and others represent how serious a ValidationProblem is
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Runnable returns the Runnable referenced by a step's fn, which can be namespaced (namespace#fn),
+// a full FQFN (namespace#fn@version), or bare if the Runnable is in the default namespace
+func (d *Directive) Runnable(fn string) (*Runnable, error) {
+	for i, r := range d.Runnables {
+		namespaced := fmt.Sprintf("%s#%s", d.runnableNamespace(r), r.Name)
+
+		if fn == namespaced || (d.runnableNamespace(r) == NamespaceDefault && fn == r.Name) || fn == fmt.Sprintf("%s@%s", namespaced, d.runnableVersion(r)) {
+			return &d.Runnables[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("fn %s does not exist", fn)
+}
+
+// HandlerFor returns the handler for the given method and resource. The method is case-insensitive,
+// and an empty method finds a handler that has no method, such as one for a stream. If there are handlers
+// for more than one version of the API, none is returned and HandlerForVersion should be used instead
+func (d *Directive) HandlerFor(method, resource string) (*Handler, bool) {
+	var found *Handler
+
+	for i, h := range d.Handlers {
+		if h.handles(method, resource) {
+			if found != nil && found.Version != h.Version {
+				return nil, false
+			}
+
+			if found == nil {
+				found = &d.Handlers[i]
+			}
+		}
+	}
+
+	return found, found != nil
+}
+
+// HandlerForVersion returns the handler for the given method and resource that serves the given version of the API,
+// an empty version finds a handler that has no version
+func (d *Directive) HandlerForVersion(method, resource, version string) (*Handler, bool) {
+	for i, h := range d.Handlers {
+		if h.handles(method, resource) && h.Version == version {
+			return &d.Handlers[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// routable returns true if the handler is both enabled and public, i.e. it should receive external requests
+func (h *Handler) routable() bool {
+	return h.IsEnabled() && !h.Internal
+}
+
+// handles returns true if the handler is for the given (case-insensitive) method and resource. Request resources
+// are compared in their normalized form, so 'users' and '/users/' both find the handler for '/users'
+func (h *Handler) handles(method, resource string) bool {
+	if !strings.EqualFold(h.Input.Method, method) {
+		return false
+	}
+
+	if h.Input.Type == InputTypeRequest || h.Input.Type == "" {
+		return normalizeResource(h.Input.Resource) == normalizeResource(resource)
+	}
+
+	return h.Input.Resource == resource
+}
+
+// MatchRequest returns the first routable request handler (in declaration order, skipping internal and disabled
+// handlers) whose input matches the given method and concrete path, along with the values of its path params. An error is returned if no handler matches, or if
+// handlers for more than one version of the API match, in which case MatchRequestVersion should be used instead
+func (d *Directive) MatchRequest(method, path string) (*Handler, map[string]string, error) {
+	handler, params, err := d.matchRequest(method, path, func(h Handler) bool { return true })
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, h := range d.Handlers {
+		if h.Input.Type == InputTypeRequest && h.routable() && h.Version != handler.Version {
+			if _, ok := h.Input.Match(method, path); ok {
+				return nil, nil, fmt.Errorf("path %s matches handlers for more than one version, including %q and %q", path, handler.Version, h.Version)
+			}
+		}
+	}
+
+	return handler, params, nil
+}
+
+// MatchRequestVersion is like MatchRequest, but only considers the handlers that serve the given version
+// of the API, an empty version considers the handlers that have no version
+func (d *Directive) MatchRequestVersion(method, path, version string) (*Handler, map[string]string, error) {
+	return d.matchRequest(method, path, func(h Handler) bool { return h.Version == version })
+}
+
+// matchRequest returns the first routable request handler accepted by filter whose input matches the method and path
+func (d *Directive) matchRequest(method, path string, filter func(h Handler) bool) (*Handler, map[string]string, error) {
+	pathMatched := false
+
+	for i, h := range d.Handlers {
+		if h.Input.Type != InputTypeRequest || !h.routable() || !filter(h) {
+			continue
+		}
+
+		if params, ok := h.Input.Match(method, path); ok {
+			return &d.Handlers[i], params, nil
+		}
+
+		// check if the path would have matched with a different method to give a more useful error
+		if _, ok := h.Input.Match(h.Input.Method, path); ok {
+			pathMatched = true
+		}
+	}
+
+	if pathMatched {
+		return nil, nil, fmt.Errorf("no handler for method %s on path %s", strings.ToUpper(method), path)
+	}
+
+	return nil, nil, fmt.Errorf("no handler for path %s", path)
+}
+
+// ActiveHandlers returns the handlers that are enabled
+func (d *Directive) ActiveHandlers() []Handler {
+	active := []Handler{}
+	for _, h := range d.Handlers {
+		if h.IsEnabled() {
+			active = append(active, h)
+		}
+	}
+
+	return active
+}
+
+// PublicHandlers returns the handlers that are not internal, i.e. the ones to be routed externally
+func (d *Directive) PublicHandlers() []Handler {
+	public := []Handler{}
+	for _, h := range d.Handlers {
+		if !h.Internal {
+			public = append(public, h)
+		}
+	}
+
+	return public
+}
+
+// ActiveSchedules returns the schedules that are enabled
+func (d *Directive) ActiveSchedules() []Schedule {
+	active := []Schedule{}
+	for _, s := range d.Schedules {
+		if s.IsEnabled() {
+			active = append(active, s)
+		}
+	}
+
+	return active
+}
+
+// IsEnabled returns true if the handler is enabled, which is the default
+func (h *Handler) IsEnabled() bool {
+	return h.Enabled == nil || *h.Enabled
+}
+
+// IsEnabled returns true if the schedule is enabled, which is the default
+func (s *Schedule) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// ScheduleByName returns the schedule with the given name
+func (d *Directive) ScheduleByName(name string) (*Schedule, bool) {
+	for i, s := range d.Schedules {
+		if s.Name == name {
+			return &d.Schedules[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Functions returns every fn called by the Directive's middleware, handlers, and schedules in declaration order,
+// including the members of groups and the fns of ForEach steps
+func (d *Directive) Functions() []CallableFn {
+	fns := []CallableFn{}
+
+	for _, step := range d.Middleware {
+		fns = append(fns, step.callableFns()...)
+	}
+
+	for _, h := range d.Handlers {
+		for _, step := range h.Steps {
+			fns = append(fns, step.callableFns()...)
+		}
+	}
+
+	for _, s := range d.Schedules {
+		for _, step := range s.Steps {
+			fns = append(fns, step.callableFns()...)
+		}
+	}
+
+	return fns
+}
+
+// Namespaces returns the sorted, unique namespaces of the Directive's runnables
+func (d *Directive) Namespaces() []string {
+	namespaces := map[string]bool{}
+	for _, r := range d.Runnables {
+		namespaces[d.runnableNamespace(r)] = true
+	}
+
+	return sortedKeys(namespaces)
+}
+
+// UsedRunnables returns the set of FQFNs of the runnables that are called by a handler or schedule,
+// fns that don't reference a declared runnable are ignored
+func (d *Directive) UsedRunnables() map[string]bool {
+	used := map[string]bool{}
+
+	for _, fn := range d.Functions() {
+		if fqfn, err := d.FQFN(fn.Fn); err == nil {
+			used[fqfn] = true
+		}
+	}
+
+	return used
+}
+
+// RequiresAtmoVersion returns true if a running Atmo of version 'have' can serve the directive.
+// The directive's AtmoVersion is treated as a minimum: 'have' must be equal to or newer than it
+// (according to semver.Compare) and must share its major version
+func (d *Directive) RequiresAtmoVersion(have string) (bool, error) {
+	if !semver.IsValid(d.AtmoVersion) {
+		return false, fmt.Errorf("directive atmo version %s is not a valid semantic version", d.AtmoVersion)
+	}
+
+	if !semver.IsValid(have) {
+		return false, fmt.Errorf("atmo version %s is not a valid semantic version", have)
+	}
+
+	if semver.Major(have) != semver.Major(d.AtmoVersion) {
+		return false, nil
+	}
+
+	return semver.Compare(have, d.AtmoVersion) >= 0, nil
+}
+
+// ValidationProblem describes a single problem found while validating a Directive,
+// along with the kind and name of the element that caused it
+type ValidationProblem struct {
+	Kind      string
+	Name      string
+	StepIndex int
+	Message   string
+	Severity  string
+
+	// Line is the line in the source YAML of the step, or otherwise the element, that the problem is about.
+	// It is 0 when not known, i.e. for problems with the Directive as a whole, or a Directive built in code
+	Line int
+}
+
+// Error returns the problem's message
+func (v ValidationProblem) Error() string {
+	return v.Message
+}
+
+// Validate validates a directive
+func (d *Directive) Validate() error {
+	return d.validate(nil, ValidateOptions{}).render()
+}
+
+// ValidateDetailed validates a directive and returns each problem found individually
+func (d *Directive) ValidateDetailed() []ValidationProblem {
+	return d.validate(nil, ValidateOptions{}).list
+}
+
+// ValidateFunc validates a directive and calls fn with each problem (a ValidationProblem) as it is found,
+// validation stops early if fn returns false
+func (d *Directive) ValidateFunc(fn func(problem error) bool) {
+	d.validate(fn, ValidateOptions{})
+}
+
+// ValidateStrict validates a directive, additionally checking for problems that are usually
+// only warnings (such as unused runnables) and treating any warnings as errors
+func (d *Directive) ValidateStrict() error {
+	return d.ValidateWithOptions(ValidateOptions{Strict: true})
+}
+
+// ValidateOptions configures the optional checks performed by ValidateWithOptions,
+// the zero value performs the same checks as Validate
+type ValidateOptions struct {
+	// Strict performs the same checks as ValidateStrict
+	Strict bool
+
+	// MaxSteps, if positive, is the largest number of steps that a handler or schedule can have
+	MaxSteps int
+
+	// MaxStepFns, if positive, is the largest number of fns (including group members) that a handler or schedule
+	// can call, otherwise DefaultMaxStepFns is used
+	MaxStepFns int
+
+	// MaxDepth, if positive, is the deepest that a handler or schedule's steps can be nested,
+	// otherwise DefaultMaxDepth is used
+	MaxDepth int
+
+	// SkipDisabledSteps skips validating the steps (and response) of disabled handlers and schedules,
+	// so that work-in-progress ones don't prevent the directive from loading
+	SkipDisabledSteps bool
+
+	// RequireDescriptions flags public handlers that have no description, i.e. for APIs that are published.
+	// Internal handlers aren't part of the published API, so they don't need one
+	RequireDescriptions bool
+
+	// RequireRunnableDescriptions flags runnables that have no description
+	RequireRunnableDescriptions bool
+
+	// MinScheduleInterval, if positive, is the smallest 'every' interval (in seconds) that a schedule can have
+	MinScheduleInterval int
+
+	// AllowNonDNSNames allows runnable names and namespaces that aren't DNS labels, such as the camelCase names
+	// of directives written before they were required, even though they may not work with registries and DNS-based routing
+	AllowNonDNSNames bool
+
+	// KnownFns are FQFNs (or bare names) available to the directive's steps in addition to its own runnables,
+	// i.e. remote functions provided by another application
+	KnownFns map[string]bool
+}
+
+// maxStepFns returns the largest number of fns that a handler or schedule can call
+func (o ValidateOptions) maxStepFns() int {
+	if o.MaxStepFns > 0 {
+		return o.MaxStepFns
+	}
+
+	return DefaultMaxStepFns
+}
+
+// maxDepth returns the deepest that a handler or schedule's steps can be nested
+func (o ValidateOptions) maxDepth() int {
+	if o.MaxDepth > 0 {
+		return o.MaxDepth
+	}
+
+	return DefaultMaxDepth
+}
+
+// ValidateWithOptions validates a directive, performing the optional checks configured by opts
+func (d *Directive) ValidateWithOptions(opts ValidateOptions) error {
+	return d.validate(nil, opts).render()
+}
+
+// ValidateAgainst validates a directive, treating the fns in known as available to its steps
+// alongside the ones it declares, so that a directive composing remote functions can be validated
+func (d *Directive) ValidateAgainst(known map[string]bool) error {
+	return d.validate(nil, ValidateOptions{KnownFns: known}).render()
+}
+
+// Warnings returns the messages of the warnings found by the most recent call to one of the Validate methods.
+// Warnings do not cause Validate to fail, but are rendered as errors by ValidateStrict
+func (d *Directive) Warnings() []string {
+	warnings := make([]string, len(d.warnings))
+	copy(warnings, d.warnings)
+
+	return warnings
+}
+
+// label returns a name for the directive to be used in problem messages
+func (d *Directive) label() string {
+	if d.Identifier == "" {
+		return "unnamed directive"
+	} else if d.AppVersion == "" {
+		return d.Identifier
+	}
+
+	return fmt.Sprintf("%s@%s", d.Identifier, d.AppVersion)
+}
+
+func (d *Directive) validate(callback func(problem error) bool, opts ValidateOptions) *problems {
+	problems := &problems{directive: d.label(), callback: callback, options: opts}
+
+	if d.Identifier == "" {
+		problems.add(ProblemKindDirective, d.Identifier, -1, errors.New("identifier is missing"))
+	}
+
+	if !semver.IsValid(d.AppVersion) {
+		problems.add(ProblemKindDirective, d.Identifier, -1, errors.New("app version is not a valid semantic version"))
+	}
+
+	if !semver.IsValid(d.AtmoVersion) {
+		problems.add(ProblemKindDirective, d.Identifier, -1, errors.New("atmo version is not a valid semantic version"))
+	}
+
+	if len(d.Runnables) < 1 && len(opts.KnownFns) == 0 {
+		problems.add(ProblemKindDirective, d.Identifier, -1, errors.New("no functions listed"))
+	}
+
+	fns := map[string]bool{}
+	runnableOccurrences := map[string]int{}
+
+	for i, f := range d.Runnables {
+		if problems.stopped {
+			break
+		}
+
+		// a runnable built in code (rather than unmarshalled) may not have been given the defaultNamespace yet
+		f.Namespace = d.runnableNamespace(f)
+		namespaced := fmt.Sprintf("%s#%s", f.Namespace, f.Name)
+
+		problems.at(d.positions.runnable(namespaced, runnableOccurrences[namespaced]))
+		runnableOccurrences[namespaced]++
+
+		if _, exists := fns[namespaced]; exists {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("duplicate fn %s found", namespaced))
+			continue
+		}
+
+		if f.Name == "" {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function at position %d missing name", i))
+			continue
+		}
+
+		if !dnsLabelRegex.MatchString(f.Name) && !opts.AllowNonDNSNames {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function %s has a name that is not a valid DNS label (lowercase letters, numbers, and hyphens, at most 63 characters)", namespaced))
+		}
+
+		if f.Namespace == "" {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function at position %d missing namespace, and the directive has no defaultNamespace", i))
+		} else if !dnsLabelRegex.MatchString(f.Namespace) && !opts.AllowNonDNSNames {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function %s has a namespace that is not a valid DNS label (lowercase letters, numbers, and hyphens, at most 63 characters)", namespaced))
+		}
+
+		if f.Version != "" && !semver.IsValid(f.Version) {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function %s has a version that is not a valid semantic version", namespaced))
+		}
+
+		if f.IsExternal() {
+			if source, err := url.Parse(f.Source); err != nil || source.Scheme == "" || source.Host == "" {
+				problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function %s has a source that is not a valid URL: %s", namespaced, f.Source))
+			}
+		}
+
+		if len(f.Description) > MaxDescriptionLength {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function %s has a description longer than the maximum of %d characters", namespaced, MaxDescriptionLength))
+		} else if f.Description == "" && opts.RequireRunnableDescriptions {
+			problems.add(ProblemKindRunnable, namespaced, -1, fmt.Errorf("function %s has no description", namespaced))
+		}
+
+		// if the fn is in the default namespace, let it exist "naked" and namespaced
+		if f.Namespace == NamespaceDefault {
+			fns[f.Name] = true
+			fns[namespaced] = true
+		} else {
+			fns[namespaced] = true
+		}
+
+		// a step can also pin the fn's exact version by referencing its full FQFN
+		fns[fmt.Sprintf("%s@%s", namespaced, d.runnableVersion(f))] = true
+	}
+
+	for fqfn, known := range opts.KnownFns {
+		if known {
+			fns[fqfn] = true
+		}
+	}
+
+	if d.Defaults != nil && (d.Defaults.Retries < 0 || d.Defaults.BackoffMs < 0) {
+		problems.add(ProblemKindDirective, d.Identifier, -1, errors.New("'defaults.retries' or 'defaults.backoffMs' value is negative"))
+	}
+
+	problems.defaults = d.Defaults
+
+	problems.secrets = map[string]bool{}
+	for _, secret := range d.Secrets {
+		if secret == "" {
+			problems.add(ProblemKindDirective, d.Identifier, -1, errors.New("secrets contains an empty name"))
+		} else if problems.secrets[secret] {
+			problems.add(ProblemKindDirective, d.Identifier, -1, fmt.Errorf("duplicate secret %s found", secret))
+		}
+
+		problems.secrets[secret] = true
+	}
+
+	namespaces := map[string]map[string]bool{}
+	for _, r := range d.Runnables {
+		if namespaces[r.Name] == nil {
+			namespaces[r.Name] = map[string]bool{}
+		}
+
+		namespaces[r.Name][d.runnableNamespace(r)] = true
+	}
+
+	problems.sharedNames = map[string][]string{}
+	for name, set := range namespaces {
+		if set[NamespaceDefault] && len(set) > 1 {
+			delete(set, NamespaceDefault)
+			problems.sharedNames[name] = sortedKeys(set)
+		}
+	}
+
+	problems.at(elementPosition{})
+
+	problems.lookalikes = map[string]string{}
+	for _, h := range d.Handlers {
+		// a resource of '/' trims to nothing, and an empty fn is reported as missing rather than a lookalike
+		for _, resource := range []string{h.Input.Resource, strings.Trim(h.Input.Resource, "/")} {
+			if resource != "" {
+				problems.lookalikes[resource] = "handler resource"
+			}
+		}
+	}
+
+	for _, s := range d.Schedules {
+		if s.Name != "" {
+			problems.lookalikes[s.Name] = "schedule name"
+		}
+	}
+
+	// middleware runs before every handler, so the state it produces is available to all of them
+	middlewareState := map[string]bool{}
+	if len(d.Middleware) > 0 && !problems.stopped {
+		problems.at(elementPosition{steps: d.positions.middlewareSteps()})
+		middlewareState = validateSteps(StepContextMiddleware, "all handlers", d.Middleware, middlewareState, fns, problems)
+	}
+
+	handlers := map[string]bool{}
+	handlerOccurrences := map[string]int{}
+
+	for _, h := range d.Handlers {
+		if problems.stopped {
+			break
+		}
+
+		name := h.Input.name()
+
+		problems.at(d.positions.handler(h.key(), handlerOccurrences[h.key()]))
+		handlerOccurrences[h.key()]++
+
+		// the same input can be handled once per API version
+		if _, exists := handlers[h.normalizedKey()]; exists {
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("duplicate handler for %s found", h.normalizedKey()))
+		}
+
+		handlers[h.normalizedKey()] = true
+
+		if h.Version != "" && !semver.IsValid(h.Version) {
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has a version that is not a valid semantic version", name))
+		}
+
+		if h.Input.Resource == "" {
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for resource %s missing resource", h.Input.Resource))
+		} else if h.Input.Type == InputTypeRequest || h.Input.Type == InputTypeStream {
+			if err := validateResourcePath(h.Input.Resource); err != nil {
+				problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has an invalid resource: %s", name, err.Error()))
+			}
+		}
+
+		if len(h.Description) > MaxDescriptionLength {
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has a description longer than the maximum of %d characters", name, MaxDescriptionLength))
+		} else if h.Description == "" && opts.RequireDescriptions && !h.Internal {
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has no description", name))
+		}
+
+		switch h.Input.Type {
+		case "":
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for resource %s missing type", h.Input.Resource))
+		case InputTypeRequest:
+			if h.Input.Method == "" {
+				problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for resource %s is of type request, but does not specify a method", h.Input.Resource))
+			} else if _, known := httpMethods[h.Input.Method]; !known {
+				problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for resource %s has an invalid method %s (methods must be uppercase HTTP verbs)", h.Input.Resource, h.Input.Method))
+			}
+		case InputTypeStream, InputTypeEvent:
+			// streams and events are identified by their resource (the stream or topic name) alone, no method is needed
+			if h.Input.Method != "" {
+				problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for resource %s is of type %s, but specifies the HTTP method %s, which only applies to requests", h.Input.Resource, h.Input.Type, h.Input.Method))
+			}
+		default:
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for resource %s has unknown type %s", h.Input.Resource, h.Input.Type))
+		}
+
+		headerNames := make([]string, 0, len(h.Headers))
+		for header := range h.Headers {
+			headerNames = append(headerNames, header)
+		}
+
+		sort.Strings(headerNames)
+
+		for _, header := range headerNames {
+			// CR and LF would allow a value to inject additional headers into the response
+			if strings.TrimSpace(header) == "" {
+				problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has a response header with an empty name", name))
+			} else if strings.ContainsAny(header, "\r\n") || strings.ContainsAny(h.Headers[header], "\r\n") {
+				problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has response header %q containing a CR or LF character", name, header))
+			}
+		}
+
+		if len(h.Steps) == 0 {
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for resource %s missing steps", h.Input.Resource))
+			continue
+		}
+
+		if !h.IsEnabled() && opts.SkipDisabledSteps {
+			continue
+		}
+
+		// user can provide default values via the handler.State field, so let's prime the state with it.
+		initialState := map[string]bool{}
+		for k := range middlewareState {
+			initialState[k] = true
+		}
+
+		for k := range h.State {
+			initialState[k] = true
+		}
+
+		fullState := validateSteps(StepContextHandler, name, h.Steps, initialState, fns, problems)
+
+		lastStep := h.Steps[len(h.Steps)-1]
+		if h.Response == "" && lastStep.IsGroup() {
+			problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has group as last step but does not include 'response' field", name))
+		} else if h.Response != "" {
+			if _, exists := fullState[h.responseKey()]; !exists {
+				problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s lists response state key that does not exist: %s", name, h.Response))
+			} else {
+				if lastStep.IsGroup() {
+					// the group's members run concurrently, so the response must unambiguously come from one of them
+					producers := 0
+					for _, fn := range lastStep.Group {
+						if fn.key() == h.responseKey() {
+							producers++
+						}
+					}
+
+					if producers == 0 {
+						problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has group as last step, but its response state key %s is not produced by a member of that group", name, h.Response))
+					} else if producers > 1 {
+						problems.add(ProblemKindHandler, name, -1, fmt.Errorf("handler for %s has group as last step, but its response state key %s is produced by more than one member of that group", name, h.Response))
+					}
+				}
+
+				validateResponseProducer(name, h, problems)
+			}
+		} else if lastStep.IsFn() && lastStep.Response == "" && problems.options.Strict {
+			// without a 'response' field the handler responds with the last step's output, which is empty if it fails and continues
+			if lastStep.OnErr != nil && lastStep.OnErr.uses("continue") {
+				problems.warn(ProblemKindHandler, name, len(h.Steps)-1, fmt.Errorf("handler for %s responds with the output of its last step, which continues on error, so the response would be empty when it fails", name))
+			}
+		}
+
+		if problems.options.Strict {
+			d.validateUnconsumedOutputs(name, h, problems)
+		}
+	}
+
+	schedules := map[string]bool{}
+	scheduleOccurrences := map[string]int{}
+
+	for i, s := range d.Schedules {
+		if problems.stopped {
+			break
+		}
+
+		problems.at(d.positions.schedule(s.Name, scheduleOccurrences[s.Name]))
+		scheduleOccurrences[s.Name]++
+
+		if s.Name != "" {
+			if _, exists := schedules[s.Name]; exists {
+				problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("duplicate schedule name %s", s.Name))
+			}
+
+			schedules[s.Name] = true
+		}
+
+		s.validate(i, fns, problems)
+	}
+
+	problems.at(elementPosition{})
+
+	if opts.Strict {
+		d.validateUnusedRunnables(problems)
+	}
+
+	problems.sort()
+
+	d.warnings = problems.warnings()
+
+	return problems
+}
+
+// Validate validates the schedule in isolation, given the names of the fns that exist (such as 'default#fn'),
+// returning the errors found. Checks that involve the rest of the Directive, such as duplicate names, are not performed
+func (s *Schedule) Validate(knownFns map[string]bool) []error {
+	problems := &problems{}
+	s.validate(0, knownFns, problems)
+
+	errs := []error{}
+	for _, problem := range problems.list {
+		if problem.Severity == SeverityError {
+			errs = append(errs, problem)
+		}
+	}
+
+	return errs
+}
+
+// validate adds the problems with the schedule at the given position in the Directive
+func (s *Schedule) validate(position int, fns map[string]bool, problems *problems) {
+	if s.Name == "" {
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule at position %d has no name", position))
+		return
+	}
+
+	if len(s.Description) > MaxDescriptionLength {
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s has a description longer than the maximum of %d characters", s.Name, MaxDescriptionLength))
+	}
+
+	if len(s.Steps) == 0 {
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s missing steps", s.Name))
+		return
+	}
+
+	hasEvery := s.Every.Seconds != 0 || s.Every.Minutes != 0 || s.Every.Hours != 0 || s.Every.Days != 0
+
+	if s.Cron != "" && hasEvery {
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s has both 'cron' and 'every' values, only one can be used", s.Name))
+	} else if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s has an invalid 'cron' value: %s", s.Name, err.Error()))
+		}
+	} else if !hasEvery {
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s has no 'every' or 'cron' values", s.Name))
+	} else if s.Every.Seconds < 0 || s.Every.Minutes < 0 || s.Every.Hours < 0 || s.Every.Days < 0 {
+		// with no negative values and at least one non-zero value, the interval is always at least one second
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s has negative 'every' values, which are not allowed", s.Name))
+	} else if seconds, err := s.NumberOfSecondsChecked(); err != nil {
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s has an invalid 'every' value: %s", s.Name, err.Error()))
+	} else if min := problems.options.MinScheduleInterval; min > 0 && seconds < min {
+		problems.add(ProblemKindSchedule, s.Name, -1, fmt.Errorf("schedule %s runs every %d seconds, more often than the minimum interval of %d seconds", s.Name, seconds, min))
+	}
+
+	if !s.IsEnabled() && problems.options.SkipDisabledSteps {
+		return
+	}
+
+	// user can provide an 'initial state' via the schedule.State field, so let's prime the state with it.
+	initialState := map[string]bool{}
+	for k := range s.State {
+		initialState[k] = true
+	}
+
+	// a step that produces a key from the initial state overwrites it, which is almost always a mistake
+	for j, step := range s.Steps {
+		for _, fn := range step.callableFns() {
+			if _, exists := s.State[fn.key()]; exists {
+				problems.warn(ProblemKindSchedule, s.Name, j, fmt.Errorf("schedule %s has step %d producing %s, which overwrites the %s key from the schedule's initial state", s.Name, j, fn.key(), fn.key()))
+			}
+		}
+	}
+
+	validateSteps(StepContextSchedule, s.Name, s.Steps, initialState, fns, problems)
+
+	// a schedule has no response, so the results of a group at the end are discarded, which is sometimes a mistake
+	if last := len(s.Steps) - 1; problems.options.Strict && s.Steps[last].IsGroup() {
+		outputs := []string{}
+		for _, fn := range s.Steps[last].Group {
+			outputs = append(outputs, fn.key())
+		}
+
+		problems.warn(ProblemKindSchedule, s.Name, last, fmt.Errorf("schedule %s ends with a group at step %d whose outputs (%s) are never used, since schedules have no response", s.Name, last, strings.Join(outputs, ", ")))
+	}
+}
+
+// validateUnusedRunnables warns about runnables that are never called by a handler or schedule
+func (d *Directive) validateUnusedRunnables(problems *problems) {
+	used := map[string]bool{}
+
+	for _, fn := range d.Functions() {
+		namespaced := fn.Fn
+		if namespace, name, _, err := ParseFQFN(fn.Fn); err == nil {
+			namespaced = fmt.Sprintf("%s#%s", namespace, name)
+		} else if !strings.Contains(namespaced, "#") {
+			namespaced = fmt.Sprintf("%s#%s", NamespaceDefault, fn.Fn)
+		}
+
+		used[namespaced] = true
+	}
+
+	occurrences := map[string]int{}
+
+	for _, r := range d.Runnables {
+		namespaced := fmt.Sprintf("%s#%s", d.runnableNamespace(r), r.Name)
+
+		problems.at(d.positions.runnable(namespaced, occurrences[namespaced]))
+		occurrences[namespaced]++
+
+		if _, isUsed := used[namespaced]; !isUsed {
+			problems.warn(ProblemKindRunnable, namespaced, -1, fmt.Errorf("fn %s is not used by any handler or schedule", namespaced))
+		}
+	}
+}
+
+// StepContext describes what a list of steps belongs to, which affects how they are validated
+type StepContext string
+
+// StepContextHandler and others are the contexts that steps can be validated in
+const (
+	StepContextHandler    = StepContext(ProblemKindHandler)
+	StepContextSchedule   = StepContext(ProblemKindSchedule)
+	StepContextMiddleware = StepContext(ProblemKindMiddleware)
+)
+
+// checkStepLimits returns an error if the steps are nested more deeply, or call more fns, than opts allows,
+// along with the index of the step that is nested too deeply (or -1 for too many fns)
+func checkStepLimits(exType StepContext, name string, steps []Executable, opts ValidateOptions) (int, error) {
+	stepFns := 0
+	for j, s := range steps {
+		if depth := s.depth(); depth > opts.maxDepth() {
+			return j, fmt.Errorf("%s for %s has step %d nested %d levels deep, more than the maximum of %d", exType, name, j, depth, opts.maxDepth())
+		}
+
+		stepFns += len(s.callableFns())
+	}
+
+	if stepFns > opts.maxStepFns() {
+		return -1, fmt.Errorf("%s for %s calls %d fns, more than the maximum of %d", exType, name, stepFns, opts.maxStepFns())
+	}
+
+	return -1, nil
+}
+
+// checkLimits returns an error if any of the Directive's handlers, schedules, or middleware exceed the default
+// nesting depth or number of fns, so that a hostile directive is rejected as it is unmarshalled
+func (d *Directive) checkLimits() error {
+	if _, err := checkStepLimits(StepContextMiddleware, "all handlers", d.Middleware, ValidateOptions{}); err != nil {
+		return err
+	}
+
+	for _, h := range d.Handlers {
+		if _, err := checkStepLimits(StepContextHandler, h.Input.name(), h.Steps, ValidateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range d.Schedules {
+		if _, err := checkStepLimits(StepContextSchedule, s.Name, s.Steps, ValidateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stateShadow describes a state key that was produced by one step and then overwritten by another
+type stateShadow struct {
+	producer int
+	shadower int
+}
+
+// ValidateSteps validates a list of steps outside of a Directive, i.e. for a handler that is being built. The
+// name identifies the handler or schedule in problem messages, initialState contains the state keys available before
+// the first step runs, and knownFns contains the names of the fns that the steps can call (such as 'default#fn')
+func ValidateSteps(ctx StepContext, name string, steps []Executable, initialState, knownFns map[string]bool) []ValidationProblem {
+	problems := &problems{}
+
+	// validateSteps adds each step's outputs to the state it is given
+	state := make(map[string]bool, len(initialState))
+	for key := range initialState {
+		state[key] = true
+	}
+
+	validateSteps(ctx, name, steps, state, knownFns, problems)
+
+	return problems.list
+}
+
+func validateSteps(exType StepContext, name string, steps []Executable, initialState map[string]bool, fns map[string]bool, problems *problems) map[string]bool {
+	// keep track of the functions that have run so far at each step
+	fullState := initialState
+
+	if step, err := checkStepLimits(exType, name, steps, problems.options); err != nil {
+		problems.add(string(exType), name, step, err)
+		return fullState
+	}
+
+	if maxSteps := problems.options.MaxSteps; maxSteps > 0 && len(steps) > maxSteps {
+		problems.add(string(exType), name, -1, fmt.Errorf("%s for %s has %d steps, more than the maximum of %d", exType, name, len(steps), maxSteps))
+	}
+
+	// keep track of which step produced each state key, and which keys have been overwritten by a later step
+	producers := map[string]int{}
+	shadows := map[string]stateShadow{}
+
+	for j, s := range steps {
+		fnsToAdd := []string{}
+
+		if !s.IsFn() && !s.IsGroup() && !s.IsForEach() {
+			problems.add(string(exType), name, j, fmt.Errorf("step at position %d for %s %s isn't an Fn, Group, or ForEach", j, exType, name))
+		}
+
+		// siblings are the keys produced by the other members of the fn's group, if it is in one
+		validateFn := func(fn CallableFn, siblings map[string]bool) {
+			available := fullState
+
+			// references to siblings are reported below with a more specific problem, so don't report them as unknown keys
+			if len(siblings) > 0 {
+				available = make(map[string]bool, len(fullState)+len(siblings))
+				for key := range fullState {
+					available[key] = true
+				}
+
+				for key := range siblings {
+					available[key] = true
+				}
+			}
+
+			knownFns := fns
+
+			if kind, looksLike := problems.lookalikes[fn.Fn]; looksLike && !fns[fn.Fn] {
+				problems.add(string(exType), name, j, fmt.Errorf("%s for %s has fn %s at step %d, which looks like a %s rather than a function", exType, name, fn.Fn, j, kind))
+
+				// the problem above replaces the usual 'does not exist' error
+				knownFns = map[string]bool{fn.Fn: true}
+				for f := range fns {
+					knownFns[f] = true
+				}
+			}
+
+			// negative defaults are reported once for the directive, so don't inherit them into every fn
+			if defaults := problems.defaults; defaults != nil && defaults.Retries >= 0 && defaults.BackoffMs >= 0 {
+				fn.OnErr = defaults.inherit(fn.OnErr)
+			}
+
+			// the problems are described as part of the step, with the same messages as before CallableFn.Validate existed
+			for _, err := range fn.Validate(available, knownFns) {
+				if fnErr, ok := err.(*fnError); ok {
+					problems.add(string(exType), name, j, fnErr.inStep(exType, name, j))
+				} else {
+					problems.add(string(exType), name, j, fmt.Errorf("%s for %s has an invalid fn at step %d: %s", exType, name, j, err.Error()))
+				}
+			}
+
+			// a bare name always resolves to the default namespace, but the author may have meant one of the others
+			if others, shared := problems.sharedNames[fn.Fn]; shared {
+				problems.warn(string(exType), name, j, fmt.Errorf("%s for %s has fn %s at step %d, which resolves to %s#%s but a fn of the same name exists in %s, use a namespaced name to avoid ambiguity", exType, name, fn.Fn, j, NamespaceDefault, fn.Fn, strings.Join(others, ", ")))
+			}
+
+			// secrets are only checked when validating a whole Directive, which declares them
+			if problems.secrets != nil {
+				for _, secret := range fn.secrets() {
+					if secret != "" && !problems.secrets[secret] {
+						problems.add(string(exType), name, j, fmt.Errorf("%s for %s has fn at step %d referencing secret %s, which is not declared in the directive's secrets", exType, name, j, secret))
+					}
+				}
+			}
+
+			// goto is not allowed in a ForEach, which is reported below
+			for _, target := range fn.OnErr.gotoTargets() {
+				if !s.IsForEach() && !namedStepAfter(steps, j, target) {
+					problems.add(string(exType), name, j, fmt.Errorf("%s for %s has fn at step %d with error directive %s%s, but no later step has 'as' value %s", exType, name, j, errDirectiveGoto, target, target))
+				}
+			}
+
+			reported := map[string]bool{}
+			for _, key := range fn.stateKeys() {
+				if siblings[key] && !fullState[key] && !reported[key] {
+					problems.add(string(exType), name, j, fmt.Errorf("group at step %d for %s %s has fn %s referencing %s, which is produced by another member of the same group (group members run concurrently)", j, exType, name, fn.Fn, key))
+					reported[key] = true
+				}
+			}
+
+			// overwriting a key is often intentional, i.e. a step that transforms its input in place, so this is only a warning
+			for _, a := range fn.With.Aliases() {
+				key := a.Key
+				if shadow, shadowed := shadows[key]; shadowed {
+					problems.warn(string(exType), name, j, fmt.Errorf("%s for %s has 'with' value at step %d referencing %s, which is produced by step %d but overwritten by step %d", exType, name, j, key, shadow.producer, shadow.shadower))
+
+					// only report each overwrite once
+					delete(shadows, key)
+				}
+			}
+
+			fnsToAdd = append(fnsToAdd, fn.key())
+		}
+
+		if s.IsFn() {
+			validateFn(s.CallableFn, nil)
+		} else if s.IsGroup() {
+			groupKeys := map[string]bool{}
+
+			for _, gfn := range s.Group {
+				if _, exists := groupKeys[gfn.key()]; exists {
+					problems.add(string(exType), name, j, fmt.Errorf("group at step %d for %s %s has duplicate alias %s", j, exType, name, gfn.key()))
+				}
+
+				groupKeys[gfn.key()] = true
+			}
+
+			for _, gfn := range s.Group {
+				siblings := map[string]bool{}
+				for key := range groupKeys {
+					if key != gfn.key() {
+						siblings[key] = true
+					}
+				}
+
+				validateFn(gfn, siblings)
+			}
+		} else if s.IsForEach() {
+			if s.ForEach.In == "" {
+				problems.add(string(exType), name, j, fmt.Errorf("ForEach at position %d for %s %s is missing 'in' value", j, exType, name))
+			} else if _, exists := fullState[s.ForEach.In]; !exists {
+				problems.add(string(exType), name, j, fmt.Errorf("ForEach at position %d for %s %s references unknown state key: %s", j, exType, name, s.ForEach.In))
+			}
+
+			if s.ForEach.As == "" {
+				problems.add(string(exType), name, j, fmt.Errorf("ForEach at position %d for %s %s is missing 'as' value", j, exType, name))
+			} else if s.ForEach.As == s.ForEach.In {
+				problems.add(string(exType), name, j, fmt.Errorf("ForEach at position %d for %s %s has the same 'as' and 'in' value %s, which would overwrite the state key being iterated", j, exType, name, s.ForEach.In))
+			}
+
+			if s.ForEach.Parallelism < 0 {
+				problems.add(string(exType), name, j, fmt.Errorf("ForEach at position %d for %s %s has negative 'parallelism' value", j, exType, name))
+			}
+
+			// it's ambiguous whether 'return' would end the whole loop or the single iteration, so it is not allowed
+			if s.ForEach.OnErr != nil && s.ForEach.OnErr.uses("return") {
+				problems.add(string(exType), name, j, fmt.Errorf("ForEach at position %d for %s %s uses the 'return' error directive, use 'continue' instead", j, exType, name))
+			} else if len(s.ForEach.OnErr.gotoTargets()) > 0 {
+				problems.add(string(exType), name, j, fmt.Errorf("ForEach at position %d for %s %s uses a '%s' error directive, use 'continue' instead", j, exType, name, errDirectiveGoto))
+			}
+
+			validateFn(s.ForEach.callableFn(), nil)
+		}
+
+		for _, newFn := range fnsToAdd {
+			if producer, exists := producers[newFn]; exists {
+				shadows[newFn] = stateShadow{producer: producer, shadower: j}
+			}
+
+			producers[newFn] = j
+			fullState[newFn] = true
+		}
+
+		if s.Response != "" {
+			// schedules have nothing to respond to
+			if exType == StepContextSchedule {
+				problems.add(string(exType), name, j, fmt.Errorf("step at position %d for %s %s has a 'response' value, which is only allowed in handlers", j, exType, name))
+			} else if _, exists := fullState[s.responseKey()]; !exists {
+				problems.add(string(exType), name, j, fmt.Errorf("step at position %d for %s %s lists response state key that does not exist: %s", j, exType, name, s.Response))
+			}
+		}
+	}
+
+	return fullState
+}
+
+// pathParamRegex matches the name of a resource path param, i.e. 'id' in '/users/:id'
+var pathParamRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateResourcePath checks that a resource path has no empty segments and that its
+// path params (i.e. ':id') are well-formed and uniquely named
+func validateResourcePath(resource string) error {
+	segments := strings.Split(strings.Trim(resource, "/"), "/")
+	params := map[string]bool{}
+
+	for _, segment := range segments {
+		if segment == "" {
+			// a resource of only '/' has a single empty segment, which is fine
+			if len(segments) == 1 {
+				break
+			}
+
+			return errors.New("path contains an empty segment")
+		}
+
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+
+		param := segment[1:]
+
+		if !pathParamRegex.MatchString(param) {
+			return fmt.Errorf("path param %q is not a valid name", segment)
+		}
+
+		if _, exists := params[param]; exists {
+			return fmt.Errorf("path param %s is used more than once", param)
+		}
+
+		params[param] = true
+	}
+
+	return nil
+}
+
+// namedStepAfter returns true if a single fn step after position j has the given 'as' value, making it a goto target
+func namedStepAfter(steps []Executable, j int, name string) bool {
+	for _, s := range steps[j+1:] {
+		if s.IsFn() && s.As == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// responseKey returns the state key that the handler's response comes from. The response can
+// be a dotted path into a state value (i.e. 'result.body'), in which case the root segment is the key
+func (h *Handler) responseKey() string {
+	return strings.SplitN(h.Response, ".", 2)[0]
+}
+
+// RequiredInputKeys returns the sorted state keys that the handler's steps reference before any step
+// (or the handler's State) has produced them, i.e. the keys that must be provided by the handler's input.
+// The handler alone doesn't know about the Directive's middleware, see Directive.RequiredInputKeys
+func (h *Handler) RequiredInputKeys() []string {
+	return h.requiredInputKeys(map[string]bool{})
+}
+
+// RequiredInputKeys is like Handler.RequiredInputKeys, but also treats the keys that
+// the Directive's middleware produces as available before the handler's steps
+func (d *Directive) RequiredInputKeys(h Handler) []string {
+	return h.requiredInputKeys(d.middlewareKeys())
+}
+
+// requiredInputKeys returns the keys that the handler requires when the given keys are already available
+func (h *Handler) requiredInputKeys(available map[string]bool) []string {
+	for k := range h.State {
+		available[k] = true
+	}
+
+	required := map[string]bool{}
+
+	requireKey := func(key string) {
+		if _, exists := available[key]; !exists {
+			required[key] = true
+		}
+	}
+
+	for _, s := range h.Steps {
+		if s.IsForEach() {
+			requireKey(s.ForEach.In)
+		}
+
+		fns := s.callableFns()
+
+		for _, fn := range fns {
+			for _, key := range fn.stateKeys() {
+				requireKey(key)
+			}
+		}
+
+		for _, fn := range fns {
+			available[fn.key()] = true
+		}
+
+		if s.Response != "" {
+			requireKey(s.responseKey())
+		}
+	}
+
+	return sortedKeys(required)
+}
+
+// CriticalPathLength returns the number of sequential levels of fn calls in the handler. Single fns and
+// ForEach steps are one level each, and a group is one level since its members run concurrently
+func (h *Handler) CriticalPathLength() int {
+	length := 0
+	for _, s := range h.Steps {
+		if s.IsFn() || s.IsGroup() || s.IsForEach() {
+			length++
+		}
+	}
+
+	return length
+}
+
+// MaxConcurrency returns the largest number of fns that the handler could run at the same time, which is the
+// size of its largest group or the Parallelism of its most parallel ForEach. A ForEach without a Parallelism
+// hint counts as one, since the number of iterations it runs concurrently is up to the runtime
+func (h *Handler) MaxConcurrency() int {
+	max := 0
+	for _, s := range h.Steps {
+		concurrency := 0
+
+		if s.IsFn() {
+			concurrency = 1
+		} else if s.IsGroup() {
+			concurrency = len(s.Group)
+		} else if s.IsForEach() {
+			concurrency = 1
+			if s.ForEach.Parallelism > 1 {
+				concurrency = s.ForEach.Parallelism
+			}
+		}
+
+		if concurrency > max {
+			max = concurrency
+		}
+	}
+
+	return max
+}
+
+// responseKey returns the state key that the step's response comes from, following the same rules as a handler's
+func (e *Executable) responseKey() string {
+	return strings.SplitN(e.Response, ".", 2)[0]
+}
+
+// validateUnconsumedOutputs warns about 'as' outputs that no later step or response references. The last
+// step is excluded since its output is the implicit response, as are steps named to be the target of a goto
+func (d *Directive) validateUnconsumedOutputs(name string, h Handler, problems *problems) {
+	analysis := d.AnalyzeHandler(h)
+
+	gotoTargets := map[string]bool{}
+	for _, s := range h.Steps {
+		for _, fn := range s.callableFns() {
+			for _, target := range fn.OnErr.gotoTargets() {
+				gotoTargets[target] = true
+			}
+		}
+	}
+
+	for j := 0; j < len(h.Steps)-1; j++ {
+		for _, fn := range h.Steps[j].callableFns() {
+			if fn.As == "" || gotoTargets[fn.As] || (h.Response != "" && h.responseKey() == fn.As) {
+				continue
+			}
+
+			consumed := false
+			for _, later := range analysis[j+1:] {
+				for _, ref := range later.References {
+					if ref == fn.As {
+						consumed = true
+					}
+				}
+			}
+
+			// a step can also respond with its own output
+			if h.Steps[j].Response != "" && h.Steps[j].responseKey() == fn.As {
+				consumed = true
+			}
+
+			if !consumed {
+				problems.warn(ProblemKindHandler, name, j, fmt.Errorf("handler for %s has step %d producing %s, which is never used by a later step or the response", name, j, fn.As))
+			}
+		}
+	}
+}
+
+// validateResponseProducer checks the step producing a handler's response. It warns about steps that come after
+// it when it always returns on error, since the trailing steps run but cannot contribute to the response, and in strict
+// mode about it continuing on error, since the response would then be empty
+func validateResponseProducer(name string, h Handler, problems *problems) {
+	j, fn, produced := lastProducerOf(h.Steps, h.responseKey())
+	if !produced {
+		return
+	}
+
+	if j < len(h.Steps)-1 && fn.OnErr.alwaysReturns() {
+		problems.warn(ProblemKindHandler, name, j+1, fmt.Errorf("handler for %s produces its response %s at step %d, and returns if that step fails, so the steps after it cannot change the response", name, h.Response, j))
+	}
+
+	if problems.options.Strict && fn.OnErr != nil && fn.OnErr.uses("continue") {
+		problems.warn(ProblemKindHandler, name, j, fmt.Errorf("handler for %s produces its response %s at step %d with a fn that continues on error, so the response would be empty when it fails", name, h.Response, j))
+	}
+}
+
+// lastProducerOf returns the index and fn of the last step to produce the given state key
+func lastProducerOf(steps []Executable, key string) (int, CallableFn, bool) {
+	for j := len(steps) - 1; j >= 0; j-- {
+		if fn, produces := steps[j].producerOf(key); produces {
+			return j, fn, true
+		}
+	}
+
+	return -1, CallableFn{}, false
+}
+
+func (d *Directive) calculateFQFNs() {
+	d.fqfns = map[string]string{}
+
+	for _, fn := range d.Runnables {
+		namespace := d.runnableNamespace(fn)
+		namespaced := fmt.Sprintf("%s#%s", namespace, fn.Name)
+		fqfn := d.fqfnForFunc(namespace, fn.Name, d.runnableVersion(fn))
+
+		// if the function is in the default namespace, add it to the map both namespaced and not
+		if namespace == NamespaceDefault {
+			d.fqfns[fn.Name] = fqfn
+			d.fqfns[namespaced] = fqfn
+		} else {
+			d.fqfns[namespaced] = fqfn
+		}
+
+		// steps can also reference the fn by its full (unprefixed) FQFN
+		d.fqfns[fmt.Sprintf("%s@%s", namespaced, d.runnableVersion(fn))] = fqfn
+	}
+}
+
+// runnableNamespace returns the namespace used in a runnable's FQFN, a runnable without
+// its own namespace is in the directive's defaultNamespace
+func (d *Directive) runnableNamespace(r Runnable) string {
+	if r.Namespace != "" {
+		return r.Namespace
+	}
+
+	return d.DefaultNamespace
+}
+
+// runnableVersion returns the version used in a runnable's FQFN, a runnable can pin
+// its own version, otherwise it uses the directive's
+func (d *Directive) runnableVersion(r Runnable) string {
+	if r.Version != "" {
+		return r.Version
+	}
+
+	return d.AppVersion
+}
+
+func (d *Directive) fqfnForFunc(namespace, fn, version string) string {
+	return fmt.Sprintf("%s%s#%s@%s", d.fqfnPrefix, namespace, fn, version)
+}
+
+// SetFQFNPrefix sets a prefix to be prepended to every FQFN the Directive generates,
+// i.e. 'registry.example.com/' results in FQFNs like 'registry.example.com/namespace#fn@version'
+func (d *Directive) SetFQFNPrefix(prefix string) {
+	d.fqfnPrefix = prefix
+
+	// recalculate on the next call to FQFN
+	d.fqfns = nil
+}
+
+// ParseFQFN splits a FQFN generated by the Directive into its parts, after removing the prefix set by SetFQFNPrefix
+func (d *Directive) ParseFQFN(fqfn string) (namespace, fn, version string, err error) {
+	if !strings.HasPrefix(fqfn, d.fqfnPrefix) {
+		return "", "", "", fmt.Errorf("FQFN %s does not have the directive's prefix %s", fqfn, d.fqfnPrefix)
+	}
+
+	return ParseFQFN(strings.TrimPrefix(fqfn, d.fqfnPrefix))
+}
+
+// ParseFQFN splits a FQFN in the namespace#fn@version format into its parts,
+// an FQFN without a namespace (fn@version) is considered to be in the default namespace.
+// It knows nothing of prefixes, so for a FQFN with a prefix from SetFQFNPrefix use Directive.ParseFQFN,
+// otherwise the prefix becomes part of the namespace (i.e. 'registry.example.com/namespace')
+func ParseFQFN(fqfn string) (namespace, fn, version string, err error) {
+	atIndex := strings.LastIndex(fqfn, "@")
+	if atIndex == -1 {
+		return "", "", "", fmt.Errorf("FQFN %s is missing the '@' version separator", fqfn)
+	}
+
+	namespacedFn, version := fqfn[:atIndex], fqfn[atIndex+1:]
+
+	namespace, fn = NamespaceDefault, namespacedFn
+	if hashIndex := strings.Index(namespacedFn, "#"); hashIndex != -1 {
+		namespace, fn = namespacedFn[:hashIndex], namespacedFn[hashIndex+1:]
+	}
+
+	if namespace == "" || fn == "" || version == "" {
+		return "", "", "", fmt.Errorf("FQFN %s is not in the namespace#fn@version format", fqfn)
+	}
+
+	return namespace, fn, version, nil
+}
+
+// NumberOfSeconds calculates the total time in seconds for the schedule's 'every' value, see NumberOfSecondsChecked for a variant that detects overflow
+func (s *Schedule) NumberOfSeconds() int {
+	seconds := s.Every.Seconds
+	minutes := 60 * s.Every.Minutes
+	hours := 60 * 60 * s.Every.Hours
+	days := 60 * 60 * 24 * s.Every.Days
+
+	return seconds + minutes + hours + days
+}
+
+const (
+	maxInt = int(^uint(0) >> 1)
+	minInt = -maxInt - 1
+
+	// maxDurationSeconds is the largest number of seconds that fits in a time.Duration
+	maxDurationSeconds = int64(time.Duration(1<<63-1) / time.Second)
+)
+
+// NumberOfSecondsChecked calculates the total time in seconds for the schedule's 'every' value,
+// returning an error rather than a wrapped value if the total does not fit in an int or a time.Duration
+func (s *Schedule) NumberOfSecondsChecked() (int, error) {
+	parts := []struct {
+		value      int
+		multiplier int
+		unit       string
+	}{
+		{s.Every.Seconds, 1, "seconds"},
+		{s.Every.Minutes, 60, "minutes"},
+		{s.Every.Hours, 60 * 60, "hours"},
+		{s.Every.Days, 60 * 60 * 24, "days"},
+	}
+
+	total := 0
+
+	for _, part := range parts {
+		if part.value > maxInt/part.multiplier || part.value < minInt/part.multiplier {
+			return 0, fmt.Errorf("'every.%s' value %d is too large", part.unit, part.value)
+		}
+
+		seconds := part.value * part.multiplier
+
+		if (seconds > 0 && total > maxInt-seconds) || (seconds < 0 && total < minInt-seconds) {
+			return 0, errors.New("total 'every' interval is too large")
+		}
+
+		total += seconds
+	}
+
+	if int64(total) > maxDurationSeconds || int64(total) < -maxDurationSeconds {
+		return 0, errors.New("total 'every' interval is too large")
+	}
+
+	return total, nil
+}
+
+// NextRun calculates the next time after 'from' that the schedule should run,
+// using either its 'cron' or its 'every' value
+func (s *Schedule) NextRun(from time.Time) (time.Time, error) {
+	if s.Cron != "" {
+		cron, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return cron.next(from)
+	}
+
+	seconds, err := s.NumberOfSecondsChecked()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("schedule %s has an invalid 'every' value: %s", s.Name, err.Error())
+	} else if seconds <= 0 {
+		return time.Time{}, fmt.Errorf("schedule %s has no 'every' or 'cron' values", s.Name)
+	}
+
+	return from.Add(time.Duration(seconds) * time.Second), nil
+}
+
+// IsGroup returns true if the executable is a group
+func (e *Executable) IsGroup() bool {
+	return e.Fn == "" && e.Group != nil && len(e.Group) > 0 && e.ForEach == nil
+}
+
+// IsFn returns true if the executable is a group
+func (e *Executable) IsFn() bool {
+	return e.Fn != "" && e.Group == nil && e.ForEach == nil
+}
+
+// IsForEach returns true if the exectuable is a ForEach
+func (e *Executable) IsForEach() bool {
+	return e.ForEach != nil && e.Fn == "" && e.Group == nil
+}
+
+// key returns the state key that the fn's result is stored under
+func (c *CallableFn) key() string {
+	if c.As != "" {
+		return c.As
+	}
+
+	return c.Fn
+}
+
+// stateKeys returns the state keys that the fn reads, via its 'with' and 'when' values
+func (c *CallableFn) stateKeys() []string {
+	keys := []string{}
+	for _, a := range c.With.Aliases() {
+		if !strings.HasPrefix(a.Key, withSecretPrefix) {
+			keys = append(keys, a.Key)
+		}
+	}
+
+	if c.When != "" {
+		if cond, err := parseCondition(c.When); err == nil {
+			keys = append(keys, cond.stateKeys()...)
+		}
+	}
+
+	return keys
+}
+
+// secrets returns the names of the secrets that the fn references via its 'with' values
+func (c *CallableFn) secrets() []string {
+	names := []string{}
+	for _, a := range c.With.Aliases() {
+		if strings.HasPrefix(a.Key, withSecretPrefix) {
+			names = append(names, strings.TrimPrefix(a.Key, withSecretPrefix))
+		}
+	}
+
+	return names
+}
+
+// Validate validates a single fn, given the state keys available to it and the fns that exist.
+// Secret references are not state keys, and are checked against the Directive's secrets by Validate
+func (c *CallableFn) Validate(availableState map[string]bool, knownFns map[string]bool) []error {
+	errs := []error{}
+
+	if _, exists := knownFns[c.Fn]; !exists {
+		errs = append(errs, fnErrorf("%[1]s for %[2]s lists fn at step %[3]d that does not exist: %[4]s (did you forget a namespace?)", "fn does not exist: %s (did you forget a namespace?)", c.Fn))
+	}
+
+	for _, a := range c.With.Aliases() {
+		if strings.HasPrefix(a.Key, withSecretPrefix) {
+			if a.Key == withSecretPrefix {
+				errs = append(errs, fnErrorf("%[1]s for %[2]s has 'with' value at step %[3]d referencing a secret without a name", "'with' value references a secret without a name"))
+			}
+		} else if _, exists := availableState[a.Key]; !exists {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s has 'with' value at step %[3]d referencing a key that is not yet available in the handler's state: %[4]s", "'with' value references a key that is not yet available in the state: %s", a.Key))
+		}
+	}
+
+	for _, a := range c.With.Aliases() {
+		// a bare entry uses the state key as its alias, which can be namespaced
+		if a.Alias != a.Key && !aliasRegex.MatchString(a.Alias) {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s has 'with' alias %[4]q at step %[3]d, which is not a valid identifier", "'with' alias %q is not a valid identifier", a.Alias))
+		}
+	}
+
+	argNames := make([]string, 0, len(c.Args))
+	for name := range c.Args {
+		argNames = append(argNames, name)
+	}
+
+	sort.Strings(argNames)
+
+	for _, name := range argNames {
+		if _, collides := c.With[name]; collides {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s has 'args' value %[4]s at step %[3]d with the same name as a 'with' value, the fn would receive two values for it", "'args' value %s has the same name as a 'with' value, the fn would receive two values for it", name))
+		}
+	}
+
+	if c.When != "" {
+		cond, err := parseCondition(c.When)
+		if err != nil {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s has an invalid 'when' value at step %[3]d: %[4]s", "'when' value is invalid: %s", err.Error()))
+		} else {
+			for _, key := range cond.stateKeys() {
+				if _, exists := availableState[key]; !exists {
+					errs = append(errs, fnErrorf("%[1]s for %[2]s has 'when' value at step %[3]d referencing a key that is not yet available in the handler's state: %[4]s", "'when' value references a key that is not yet available in the state: %s", key))
+				}
+			}
+		}
+	}
+
+	if c.Timeout < 0 {
+		errs = append(errs, fnErrorf("%[1]s for %[2]s has negative 'timeout' value at step %[3]d", "'timeout' value is negative"))
+	} else if c.Timeout > MaxFnTimeout {
+		errs = append(errs, fnErrorf("%[1]s for %[2]s has 'timeout' value at step %[3]d greater than the maximum of %[4]d seconds", "'timeout' value is greater than the maximum of %d seconds", MaxFnTimeout))
+	}
+
+	if c.OnErr != nil {
+		// if codes are specificed, 'other' should be used, not 'any'
+		if len(c.OnErr.Code) > 0 && c.OnErr.Any != "" {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s has 'onErr.any' value at step %[3]d while specific codes are specified, use 'other' instead", "'onErr.any' value is used while specific codes are specified, use 'other' instead"))
+		} else if c.OnErr.Any != "" {
+			if !isErrDirective(c.OnErr.Any) {
+				errs = append(errs, fnErrorf("%[1]s for %[2]s has 'onErr.any' value at step %[3]d with an invalid error directive: %[4]s", "'onErr.any' value is an invalid error directive: %s", c.OnErr.Any))
+			}
+		}
+
+		// if codes are NOT specificed, 'any' should be used, not 'other'
+		if len(c.OnErr.Code) == 0 && c.OnErr.Other != "" {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s has 'onErr.other' value at step %[3]d while specific codes are not specified, use 'any' instead", "'onErr.other' value is used while specific codes are not specified, use 'any' instead"))
+		} else if c.OnErr.Other != "" {
+			if !isErrDirective(c.OnErr.Other) {
+				errs = append(errs, fnErrorf("%[1]s for %[2]s has 'onErr.any' value at step %[3]d with an invalid error directive: %[4]s", "'onErr.other' value is an invalid error directive: %s", c.OnErr.Other))
+			}
+		}
+
+		codes := make([]int, 0, len(c.OnErr.Code))
+		for code := range c.OnErr.Code {
+			codes = append(codes, code)
+		}
+
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			val := c.OnErr.Code[code]
+
+			if code < minErrCode || code > maxErrCode {
+				errs = append(errs, fnErrorf("%[1]s for %[2]s has 'onErr.code' key %[4]d at step %[3]d that is not a valid status code (%[5]d-%[6]d)", "'onErr.code' key %d is not a valid status code (%d-%d)", code, minErrCode, maxErrCode))
+			}
+
+			if !isErrDirective(val) {
+				errs = append(errs, fnErrorf("%[1]s for %[2]s has 'onErr.code' value at step %[3]d with an invalid error directive for code %[4]d: %[5]s", "'onErr.code' value is an invalid error directive for code %d: %s", code, val))
+			}
+		}
+
+		if c.OnErr.Retries < 0 || c.OnErr.BackoffMs < 0 {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s has negative 'onErr.retries' or 'onErr.backoffMs' value at step %[3]d", "'onErr.retries' or 'onErr.backoffMs' value is negative"))
+		} else if c.OnErr.uses("retry") && c.OnErr.Retries == 0 {
+			errs = append(errs, fnErrorf("%[1]s for %[2]s uses the 'retry' error directive at step %[3]d without a positive 'onErr.retries' value (or directive 'defaults.retries' value)", "'retry' error directive is used without a positive 'onErr.retries' value (or directive 'defaults.retries' value)"))
+		}
+	}
+
+	return errs
+}
+
+// fnError is an error found by CallableFn.Validate. Its message describes the problem with the fn on its own,
+// and stepFormat describes it as part of a step of a handler or schedule, which is how Validate reports it
+type fnError struct {
+	message    string
+	stepFormat string
+	args       []interface{}
+}
+
+// fnErrorf returns an fnError. The message is formatted with args, and stepFormat with the step's context, name,
+// and index as its first three arguments (i.e. '%[1]s for %[2]s ... at step %[3]d') followed by args
+func fnErrorf(stepFormat, format string, args ...interface{}) error {
+	return &fnError{message: fmt.Sprintf(format, args...), stepFormat: stepFormat, args: args}
+}
+
+func (f *fnError) Error() string {
+	return f.message
+}
+
+// inStep returns the error describing the problem as part of the given step
+func (f *fnError) inStep(exType StepContext, name string, step int) error {
+	return fmt.Errorf(f.stepFormat, append([]interface{}{exType, name, step}, f.args...)...)
+}
+
+// String returns a compact representation of the step for logging. A fn step is rendered like CallableFn.String,
+// a group like 'group[db#getUser, db#getDetails as details]', and a ForEach like 'forEach(items) process as results',
+// each followed by ' -> response' if the step sets a response
+func (e Executable) String() string {
+	str := ""
+
+	if e.IsFn() {
+		str = e.CallableFn.String()
+	} else if e.IsGroup() {
+		members := make([]string, len(e.Group))
+		for i, fn := range e.Group {
+			members[i] = fn.String()
+		}
+
+		str = fmt.Sprintf("group[%s]", strings.Join(members, ", "))
+	} else if e.IsForEach() {
+		str = fmt.Sprintf("forEach(%s) %s", e.ForEach.In, e.ForEach.Fn)
+		if e.ForEach.As != "" {
+			str += fmt.Sprintf(" as %s", e.ForEach.As)
+		}
+	}
+
+	if e.Response != "" {
+		str += fmt.Sprintf(" -> %s", e.Response)
+	}
+
+	return str
+}
+
+// Validate validates each of the fns that the step calls, given the state keys available before it and the fns that
+// exist. It replaces the promoted CallableFn.Validate, which would only check the empty embedded fn of a group or
+// ForEach step. The members of a group can't use each other's results since they run concurrently
+func (e Executable) Validate(availableState map[string]bool, knownFns map[string]bool) []error {
+	if e.IsFn() {
+		return e.CallableFn.Validate(availableState, knownFns)
+	}
+
+	errs := []error{}
+
+	if e.IsGroup() {
+		for i, fn := range e.Group {
+			for _, err := range fn.Validate(availableState, knownFns) {
+				errs = append(errs, fmt.Errorf("group member %d (%s): %w", i, fn.Fn, err))
+			}
+		}
+	} else if e.IsForEach() {
+		fn := e.ForEach.IterationFn()
+		for _, err := range fn.Validate(availableState, knownFns) {
+			errs = append(errs, fmt.Errorf("forEach: %w", err))
+		}
+	} else {
+		errs = append(errs, errors.New("step isn't an Fn, Group, or ForEach"))
+	}
+
+	return errs
+}
+
+// depth returns how many levels deep the executable's fns are nested. Groups and ForEach contain single fns
+// rather than nested steps, so no executable is currently deeper than two levels
+func (e *Executable) depth() int {
+	if e.IsGroup() || e.IsForEach() {
+		return 2
+	}
+
+	return 1
+}
+
+// callableFns returns the fns called by the executable
+func (e *Executable) callableFns() []CallableFn {
+	if e.IsFn() {
+		return []CallableFn{e.CallableFn}
+	} else if e.IsGroup() {
+		return e.Group
+	} else if e.IsForEach() {
+		return []CallableFn{e.ForEach.callableFn()}
+	}
+
+	return nil
+}
+
+// producerOf returns the fn within the executable that stores its result under the given state key, if any
+func (e *Executable) producerOf(key string) (CallableFn, bool) {
+	if e.IsFn() && e.CallableFn.key() == key {
+		return e.CallableFn, true
+	} else if e.IsGroup() {
+		for _, fn := range e.Group {
+			if fn.key() == key {
+				return fn, true
+			}
+		}
+	} else if e.IsForEach() && e.ForEach.As == key {
+		return e.ForEach.callableFn(), true
+	}
+
+	return CallableFn{}, false
+}
+
+// callableFn returns the fn that the ForEach calls for each element
+func (f *ForEach) callableFn() CallableFn {
+	return CallableFn{Fn: f.Fn, OnErr: f.OnErr, As: f.As}
+}
+
+// IterationFn returns the fn that effectively runs for each element of the ForEach. Each iteration receives a
+// single element of the 'in' value under the same name, and the results of all iterations are stored under 'as'
+func (f *ForEach) IterationFn() CallableFn {
+	fn := CallableFn{
+		Fn:    f.Fn,
+		As:    f.As,
+		With:  FnWith{f.In: f.In},
+		OnErr: f.OnErr.copy(),
+	}
+
+	return fn
+}
+
+// alwaysReturns returns true if an error from the fn will always end the execution,
+// which is the default when no onErr is specified
+func (f *FnOnErr) alwaysReturns() bool {
+	if f == nil {
+		return true
+	}
+
+	return !f.uses("continue") && len(f.gotoTargets()) == 0
+}
+
+// gotoTargets returns the sorted, unique names of the steps that the fn's error directives jump to
+func (f *FnOnErr) gotoTargets() []string {
+	if f == nil {
+		return nil
+	}
+
+	targets := map[string]bool{}
+
+	for _, val := range append([]string{f.Any, f.Other}, codeDirectives(f.Code)...) {
+		// an empty target is reported as an invalid error directive
+		if isErrDirective(val) && strings.HasPrefix(val, errDirectiveGoto) {
+			targets[strings.TrimPrefix(val, errDirectiveGoto)] = true
+		}
+	}
+
+	return sortedKeys(targets)
+}
+
+func codeDirectives(codes map[int]string) []string {
+	directives := make([]string, 0, len(codes))
+	for _, val := range codes {
+		directives = append(directives, val)
+	}
+
+	return directives
+}
+
+// uses returns true if the error directive is used for any error
+func (f *FnOnErr) uses(directive string) bool {
+	if f.Any == directive || f.Other == directive {
+		return true
+	}
+
+	for _, val := range f.Code {
+		if val == directive {
+			return true
+		}
+	}
+
+	return false
+}
+
+type problems struct {
+	list []ValidationProblem
+
+	// directive identifies the directive the problems belong to when rendered
+	directive string
+
+	// callback is called with each problem as it is added, and stops validation by returning false
+	callback func(problem error) bool
+	stopped  bool
+
+	// options configures the optional checks, and with Strict causes warnings to be rendered as errors
+	options ValidateOptions
+
+	// secrets are the names of the Directive's declared secrets, secret references are not checked if nil
+	secrets map[string]bool
+
+	// defaults are the Directive's default step values, which fns inherit while being validated
+	defaults *Defaults
+
+	// sharedNames maps the bare names of default namespace fns to the other namespaces that have a fn of the same name
+	sharedNames map[string][]string
+
+	// position is the source position of the element being validated
+	position elementPosition
+
+	// lookalikes maps the names of the Directive's other elements (such as schedule names) to what they are,
+	// so that a step referencing one by mistake gets a more helpful problem than "fn does not exist"
+	lookalikes map[string]string
+}
+
+func (p *problems) add(kind, name string, step int, err error) {
+	p.append(ValidationProblem{Kind: kind, Name: name, StepIndex: step, Message: err.Error(), Severity: SeverityError})
+}
+
+// warn adds a non-fatal problem, which is reported by ValidateDetailed but does not cause Validate to fail
+func (p *problems) warn(kind, name string, step int, err error) {
+	p.append(ValidationProblem{Kind: kind, Name: name, StepIndex: step, Message: err.Error(), Severity: SeverityWarning})
+}
+
+// at sets the source position of the element being validated, which is given to the problems added after it
+func (p *problems) at(position elementPosition) {
+	p.position = position
+}
+
+func (p *problems) append(problem ValidationProblem) {
+	if p.stopped {
+		return
+	}
+
+	problem.Line = p.position.line
+	if problem.StepIndex >= 0 && problem.StepIndex < len(p.position.steps) {
+		problem.Line = p.position.steps[problem.StepIndex]
+	}
+
+	p.list = append(p.list, problem)
+
+	if p.callback != nil && !p.callback(problem) {
+		p.stopped = true
+	}
+}
+
+// problemKindOrder ranks the kinds of problem in the order their elements appear in a Directive
+var problemKindOrder = map[string]int{
+	ProblemKindDirective:  0,
+	ProblemKindRunnable:   1,
+	ProblemKindMiddleware: 2,
+	ProblemKindHandler:    3,
+	ProblemKindSchedule:   4,
+}
+
+// sort orders the problems by kind, then name, then step index, so that the same Directive always
+// renders the same error regardless of the order in which its maps were iterated
+func (p *problems) sort() {
+	sort.SliceStable(p.list, func(i, j int) bool {
+		a, b := p.list[i], p.list[j]
+
+		if a.Kind != b.Kind {
+			return problemKindOrder[a.Kind] < problemKindOrder[b.Kind]
+		}
+
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.StepIndex < b.StepIndex
+	})
+}
+
+// warnings returns the messages of the warning problems
+func (p *problems) warnings() []string {
+	warnings := []string{}
+	for _, problem := range p.list {
+		if problem.Severity == SeverityWarning {
+			warnings = append(warnings, problem.Message)
+		}
+	}
+
+	return warnings
+}
+
+func (p *problems) render() error {
+	errs := []ValidationProblem{}
+	for _, problem := range p.list {
+		if problem.Severity == SeverityError || p.options.Strict {
+			errs = append(errs, problem)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: errs, directive: p.directive}
+}
+
+// ValidationError is the error returned when validating a Directive fails, it contains each problem found
+type ValidationError struct {
+	Problems []ValidationProblem
+
+	directive string
+}
+
+// Error returns a description of all of the problems
+func (v *ValidationError) Error() string {
+	text := fmt.Sprintf("found %d problems:", len(v.Problems))
+
+	for _, problem := range v.Problems {
+		text += fmt.Sprintf("\n\t%s: %s", v.directive, problem.Message)
+	}
+
+	return text
+}
+
+// Unwrap returns each of the problems as an error, allowing them to be inspected with errors.Is and errors.As
+func (v *ValidationError) Unwrap() []error {
+	errs := make([]error, len(v.Problems))
+	for i := range v.Problems {
+		errs[i] = v.Problems[i]
+	}
+
+	return errs
+}
+
+// Is returns true if any of the problems matches target. errors.Is only traverses Unwrap() []error from Go 1.20,
+// so the problems are checked here as well for the earlier versions that the module supports
+func (v *ValidationError) Is(target error) bool {
+	for _, err := range v.Unwrap() {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As sets target to the first of the problems that matches it, for the same reason as Is
+func (v *ValidationError) As(target interface{}) bool {
+	for _, err := range v.Unwrap() {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,4447 @@
+package directive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestYAMLMarshalUnmarshal(t *testing.T) {
+	dir := Directive{
+		Identifier:  "dev.suborbital.appname",
+		AppVersion:  "v0.1.1",
+		AtmoVersion: "v0.0.6",
+		Runnables: []Runnable{
+			{
+				Name:      "get-user",
+				Namespace: "db",
+			},
+			{
+				Name:      "get-user-details",
+				Namespace: "db",
+			},
+			{
+				Name:      "return-user",
+				Namespace: "api",
+			},
+		},
+		Handlers: []Handler{
+			{
+				Input: Input{
+					Type:     "request",
+					Method:   "GET",
+					Resource: "/api/v1/user",
+				},
+				Steps: []Executable{
+					{
+						Group: []CallableFn{
+							{
+								Fn: "db#get-user",
+							},
+							{
+								Fn: "db#get-user-details",
+							},
+						},
+					},
+					{
+						CallableFn: CallableFn{
+							Fn: "api#return-user",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	yamlBytes, err := dir.Marshal()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir2 := Directive{}
+	if err := dir2.Unmarshal(yamlBytes); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dir2.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	if len(dir2.Handlers[0].Steps) != 2 {
+		t.Error("wrong number of steps")
+		return
+	}
+
+	if len(dir2.Runnables) != 3 {
+		t.Error("wrong number of steps")
+		return
+	}
+}
+
+func TestDirectiveValidatorGroupLast(t *testing.T) {
+	dir := Directive{
+		Identifier:  "dev.suborbital.appname",
+		AppVersion:  "v0.1.1",
+		AtmoVersion: "v0.0.6",
+		Runnables: []Runnable{
+			{
+				Name:      "getUser",
+				Namespace: "db",
+			},
+			{
+				Name:      "getUserDetails",
+				Namespace: "db",
+			},
+			{
+				Name:      "returnUser",
+				Namespace: "api",
+			},
+		},
+		Handlers: []Handler{
+			{
+				Input: Input{
+					Type:     "request",
+					Method:   "GET",
+					Resource: "/api/v1/user",
+				},
+				Steps: []Executable{
+					{
+						CallableFn: CallableFn{
+							Fn: "api#returnUser",
+						},
+					},
+					{
+						Group: []CallableFn{
+							{
+								Fn: "db#getUser",
+							},
+							{
+								Fn: "db#getUserDetails",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := dir.Validate(); err == nil {
+		t.Error("directive validation should have failed")
+	} else {
+		fmt.Println("directive validation properly failed:", err)
+	}
+}
+
+func TestDirectiveValidatorInvalidOnErr(t *testing.T) {
+	dir := Directive{
+		Identifier:  "dev.suborbital.appname",
+		AppVersion:  "v0.1.1",
+		AtmoVersion: "v0.0.6",
+		Runnables: []Runnable{
+			{
+				Name:      "getUser",
+				Namespace: "db",
+			},
+			{
+				Name:      "getUserDetails",
+				Namespace: "db",
+			},
+			{
+				Name:      "returnUser",
+				Namespace: "api",
+			},
+		},
+		Handlers: []Handler{
+			{
+				Input: Input{
+					Type:     "request",
+					Method:   "GET",
+					Resource: "/api/v1/user",
+				},
+				Steps: []Executable{
+					{
+						CallableFn: CallableFn{
+							Fn: "api#returnUser",
+							OnErr: &FnOnErr{
+								Code: map[int]string{
+									400: "continue",
+								},
+								Any: "return",
+							},
+						},
+					},
+					{
+						CallableFn: CallableFn{
+							Fn: "api#returnUser",
+							OnErr: &FnOnErr{
+								Other: "continue",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := dir.Validate(); err == nil {
+		t.Error("directive validation should have failed")
+	} else {
+		fmt.Println("directive validation properly failed:", err)
+	}
+}
+
+func TestDirectiveValidatorMissingFns(t *testing.T) {
+	dir := Directive{
+		Identifier:  "dev.suborbital.appname",
+		AppVersion:  "v0.1.1",
+		AtmoVersion: "v0.0.6",
+		Runnables: []Runnable{
+			{
+				Name:      "getUser",
+				Namespace: "db",
+			},
+			{
+				Name:      "getUserDetails",
+				Namespace: "db",
+			},
+			{
+				Name:      "returnUser",
+				Namespace: "api",
+			},
+		},
+		Handlers: []Handler{
+			{
+				Input: Input{
+					Type:     "request",
+					Method:   "GET",
+					Resource: "/api/v1/user",
+				},
+				Steps: []Executable{
+					{
+						Group: []CallableFn{
+							{
+								Fn: "getUser",
+							},
+							{
+								Fn: "getFoobar",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := dir.Validate(); err == nil {
+		t.Error("directive validation should have failed")
+	} else {
+		fmt.Println("directive validation properly failed:", err)
+	}
+}
+
+func TestDirectiveFQFNs(t *testing.T) {
+	dir := Directive{
+		Identifier:  "dev.suborbital.appname",
+		AppVersion:  "v0.1.1",
+		AtmoVersion: "v0.0.6",
+		Runnables: []Runnable{
+			{
+				Name:      "getUser",
+				Namespace: "default",
+			},
+			{
+				Name:      "getUserDetails",
+				Namespace: "db",
+			},
+			{
+				Name:      "returnUser",
+				Namespace: "api",
+			},
+		},
+	}
+
+	fqfn1, err := dir.FQFN("getUser")
+	if err != nil {
+		t.Error("fqfn1 err", err)
+	}
+
+	if fqfn1 != "default#getUser@v0.1.1" {
+		t.Error("fqfn1 should be 'default#getUser@v0.1.1', got", fqfn1)
+	}
+
+	fqfn2, err := dir.FQFN("db#getUserDetails")
+	if err != nil {
+		t.Error("fqfn2 err", err)
+	}
+
+	if fqfn2 != "db#getUserDetails@v0.1.1" {
+		t.Error("fqfn2 should be 'db#getUserDetails@v0.1.1', got", fqfn2)
+	}
+
+	fqfn3, err := dir.FQFN("api#returnUser")
+	if err != nil {
+		t.Error("fqfn3 err", err)
+	}
+
+	if fqfn3 != "api#returnUser@v0.1.1" {
+		t.Error("fqfn3 should be 'api#returnUser@v0.1.1', got", fqfn3)
+	}
+
+	_, err = dir.FQFN("foo#bar")
+	if err == nil {
+		t.Error("foo#bar should have errored")
+	}
+}
+
+func TestDirectiveValidatorWithMissingState(t *testing.T) {
+	dir := Directive{
+		Identifier:  "dev.suborbital.appname",
+		AppVersion:  "v0.1.1",
+		AtmoVersion: "v0.0.6",
+		Runnables: []Runnable{
+			{
+				Name:      "getUser",
+				Namespace: "db",
+			},
+			{
+				Name:      "getUserDetails",
+				Namespace: "db",
+			},
+			{
+				Name:      "returnUser",
+				Namespace: "api",
+			},
+		},
+		Handlers: []Handler{
+			{
+				Input: Input{
+					Type:     "request",
+					Method:   "GET",
+					Resource: "/api/v1/user",
+				},
+				Steps: []Executable{
+					{
+						Group: []CallableFn{
+							{
+								Fn: "getUser",
+								With: map[string]string{
+									"data": "someData",
+								},
+							},
+							{
+								Fn: "getFoobar",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := dir.Validate(); err == nil {
+		t.Error("directive validation should have failed")
+	} else {
+		fmt.Println("directive validation properly failed:", err)
+	}
+}
+
+// testDirectiveHeader is prepended to the YAML of the directives built by testDirective
+const testDirectiveHeader = `identifier: com.suborbital.test
+appVersion: v0.1.0
+atmoVersion: v0.2.0
+`
+
+// testDirective unmarshals a directive from the given YAML, following testDirectiveHeader
+func testDirective(t *testing.T, body string) *Directive {
+	t.Helper()
+
+	dir := &Directive{}
+	if err := dir.Unmarshal([]byte(testDirectiveHeader + body)); err != nil {
+		t.Fatal("failed to Unmarshal:", err)
+	}
+
+	return dir
+}
+
+// findProblem returns the first problem whose message contains substr
+func findProblem(problems []ValidationProblem, substr string) (ValidationProblem, bool) {
+	for _, p := range problems {
+		if strings.Contains(p.Message, substr) {
+			return p, true
+		}
+	}
+
+	return ValidationProblem{}, false
+}
+
+func TestDirectiveValidateDetailed(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: db#get-user
+      - fn: db#missing
+schedules:
+  - name: cleanup
+    every:
+      seconds: 30
+    steps:
+      - fn: db#get-user
+`)
+
+	problems := dir.ValidateDetailed()
+	if len(problems) != 1 {
+		t.Fatal("expected 1 problem, got", problems)
+	}
+
+	p := problems[0]
+	if p.Kind != ProblemKindHandler || p.Name != "GET /user" || p.StepIndex != 1 {
+		t.Errorf("problem has the wrong location: %+v", p)
+	}
+
+	if !strings.Contains(p.Message, "db#missing") {
+		t.Error("problem message should name the missing fn, got", p.Message)
+	}
+
+	err := dir.Validate()
+	if err == nil {
+		t.Fatal("directive validation should have failed")
+	}
+
+	if !strings.Contains(err.Error(), p.Message) {
+		t.Errorf("Validate error %q should include the problem message %q", err.Error(), p.Message)
+	}
+}
+
+func TestDirectiveJSONRoundTrip(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: db#get-user
+        as: user
+        with:
+          id: userId
+    state:
+      userId: "1"
+`)
+
+	jsonBytes, err := dir.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(jsonBytes), `"method":"GET"`) || !strings.Contains(string(jsonBytes), `"fn":"db#get-user"`) {
+		t.Error("inline Input and CallableFn fields should be marshalled at the top level of their parents, got", string(jsonBytes))
+	}
+
+	dir2 := &Directive{}
+	if err := dir2.UnmarshalJSON(jsonBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir2.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	h := dir2.Handlers[0]
+	if h.Input.Resource != "/user" || h.Steps[0].As != "user" || h.Steps[0].With["id"] != "userId" {
+		t.Errorf("handler did not survive the round trip: %+v", h)
+	}
+
+	if dir2.fqfns == nil {
+		t.Error("UnmarshalJSON should calculate FQFNs")
+	}
+
+	fqfn, err := dir2.FQFN("db#get-user")
+	if err != nil {
+		t.Error(err)
+	} else if fqfn != "db#get-user@v0.1.0" {
+		t.Error("wrong FQFN, got", fqfn)
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	input, err := ParseInput("get /users/:id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if input.Type != InputTypeRequest || input.Method != "GET" || input.Resource != "/users/:id" {
+		t.Errorf("wrong input: %+v", input)
+	}
+
+	if _, err := ParseInput("GET"); err == nil {
+		t.Error("an input without a resource should have errored")
+	}
+
+	if _, err := ParseInput("FETCH /users"); err == nil {
+		t.Error("an input with an unknown method should have errored")
+	}
+}
+
+func TestDirectiveValidatorStreamHandlers(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: record
+    namespace: default
+handlers:
+  - type: stream
+    resource: events
+    steps:
+      - fn: record
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a stream handler without a method should be valid:", err)
+	}
+
+	dir.Handlers[0].Input.Resource = ""
+	if _, found := findProblem(dir.ValidateDetailed(), "missing resource"); !found {
+		t.Error("a stream handler without a resource should have failed")
+	}
+
+	dir.Handlers[0].Input.Resource = "events"
+	dir.Handlers[0].Input.Method = "GET"
+	if _, found := findProblem(dir.ValidateDetailed(), "only applies to requests"); !found {
+		t.Error("a stream handler with a method should have failed")
+	}
+}
+
+func TestDirectiveValidatorForEachIn(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: list-users
+    namespace: default
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users
+    steps:
+      - fn: list-users
+        as: users
+      - forEach:
+          in: users
+          fn: get-user
+          as: details
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a ForEach over a key produced by an earlier step should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[1].ForEach.In = "usres"
+	if _, found := findProblem(dir.ValidateDetailed(), "references unknown state key: usres"); !found {
+		t.Error("a ForEach over a key that is never produced should have failed")
+	}
+}
+
+func TestDirectiveValidatorForEachInScheduleState(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    state:
+      users: "[]"
+    steps:
+      - forEach:
+          in: users
+          fn: get-user
+          as: details
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a ForEach over a key in the schedule's initial state should be valid:", err)
+	}
+}
+
+func TestDirectiveValidatorStepsAfterResponse(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: audit
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    response: user
+    steps:
+      - fn: get-user
+        as: user
+        onErr:
+          any: return
+      - fn: audit
+        with:
+          user: user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("trailing steps should only be a warning:", err)
+	}
+
+	p, found := findProblem(dir.ValidateDetailed(), "cannot change the response")
+	if !found {
+		t.Fatal("trailing steps after the response producer should have been warned about")
+	}
+
+	if p.Severity != SeverityWarning || p.StepIndex != 1 {
+		t.Errorf("wrong severity or step for the problem: %+v", p)
+	}
+
+	dir.Handlers[0].Steps[0].OnErr = &FnOnErr{Any: "continue"}
+	if _, found := findProblem(dir.ValidateDetailed(), "cannot change the response"); found {
+		t.Error("a response producer that continues on error should not make the later steps redundant")
+	}
+}
+
+func TestParseFQFN(t *testing.T) {
+	namespace, fn, version, err := ParseFQFN("db#get-user@v0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if namespace != "db" || fn != "get-user" || version != "v0.1.0" {
+		t.Error("wrong parts, got", namespace, fn, version)
+	}
+
+	namespace, fn, _, err = ParseFQFN("get-user@v0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if namespace != NamespaceDefault || fn != "get-user" {
+		t.Error("a FQFN without a namespace should be in the default namespace, got", namespace, fn)
+	}
+
+	for _, fqfn := range []string{"db#get-user", "db#@v0.1.0", ""} {
+		if _, _, _, err := ParseFQFN(fqfn); err == nil {
+			t.Errorf("%q should have errored", fqfn)
+		}
+	}
+}
+
+func TestParseFQFNRoundTrip(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+`)
+
+	fqfn, err := dir.FQFN("db#get-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespace, fn, version, err := ParseFQFN(fqfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if namespace != "db" || fn != "get-user" || version != dir.AppVersion {
+		t.Error("ParseFQFN should reverse FQFN, got", namespace, fn, version)
+	}
+}
+
+func TestDirectiveRequiresAtmoVersion(t *testing.T) {
+	dir := Directive{AtmoVersion: "v0.4.0"}
+
+	cases := map[string]bool{
+		"v0.4.0": true,
+		"v0.4.2": true,
+		"v0.5.0": true,
+		"v0.3.9": false,
+		"v1.0.0": false,
+	}
+
+	for have, expected := range cases {
+		ok, err := dir.RequiresAtmoVersion(have)
+		if err != nil {
+			t.Error(have, err)
+		} else if ok != expected {
+			t.Errorf("RequiresAtmoVersion(%s) should be %t", have, expected)
+		}
+	}
+
+	if _, err := dir.RequiresAtmoVersion("0.4.0"); err == nil {
+		t.Error("an invalid running version should have errored")
+	}
+
+	dir.AtmoVersion = "latest"
+	if _, err := dir.RequiresAtmoVersion("v0.4.0"); err == nil {
+		t.Error("an invalid directive version should have errored")
+	}
+}
+
+func TestDirectiveValidatorScheduleCron(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: report
+    namespace: default
+schedules:
+  - name: weekday-report
+    cron: "0 9 * * 1-5"
+    steps:
+      - fn: report
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a schedule with a cron value should be valid:", err)
+	}
+
+	dir.Schedules[0].Every = ScheduleEvery{Hours: 1}
+	if _, found := findProblem(dir.ValidateDetailed(), "has both 'cron' and 'every' values"); !found {
+		t.Error("a schedule with both 'cron' and 'every' values should have failed")
+	}
+
+	dir.Schedules[0].Every = ScheduleEvery{}
+	dir.Schedules[0].Cron = "0 25 * * *"
+	if _, found := findProblem(dir.ValidateDetailed(), "invalid 'cron' value"); !found {
+		t.Error("a schedule with an out of range cron value should have failed")
+	}
+}
+
+func TestScheduleNextRun(t *testing.T) {
+	// a Friday
+	from := time.Date(2021, time.July, 9, 10, 0, 0, 0, time.UTC)
+
+	cron := Schedule{Name: "weekday-report", Cron: "0 9 * * 1-5"}
+
+	next, err := cron.NextRun(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := time.Date(2021, time.July, 12, 9, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Error("the next weekday run should be on Monday, got", next)
+	}
+
+	every := Schedule{Name: "refresh", Every: ScheduleEvery{Minutes: 5}}
+
+	next, err = every.NextRun(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := from.Add(5 * time.Minute); !next.Equal(expected) {
+		t.Error("the next interval run should be 5 minutes later, got", next)
+	}
+
+	if _, err := (&Schedule{Name: "never"}).NextRun(from); err == nil {
+		t.Error("a schedule without 'every' or 'cron' values should have errored")
+	}
+}
+
+func TestDirectiveRunnable(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+    lang: rust
+  - name: get-user
+    namespace: db
+    lang: go
+`)
+
+	r, err := dir.Runnable("get-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Namespace != NamespaceDefault || r.Lang != "rust" {
+		t.Errorf("a fn without a namespace should resolve to the default namespace, got %+v", r)
+	}
+
+	r, err = dir.Runnable("db#get-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Namespace != "db" || r.Lang != "go" {
+		t.Errorf("wrong runnable, got %+v", r)
+	}
+
+	if _, err := dir.Runnable("db#missing"); err == nil {
+		t.Error("an undeclared fn should have errored")
+	}
+}
+
+func TestDirectiveValidatorShadowedState(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-admin
+    namespace: default
+  - name: greet
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        as: user
+      - fn: get-admin
+        as: user
+      - fn: greet
+        with:
+          user: user
+`)
+
+	p, found := findProblem(dir.ValidateDetailed(), "produced by step 0 but overwritten by step 1")
+	if !found {
+		t.Fatal("a key overwritten before it is used should have been warned about")
+	}
+
+	if p.Severity != SeverityWarning || p.StepIndex != 2 {
+		t.Errorf("wrong severity or step for the problem: %+v", p)
+	}
+
+	if err := dir.Validate(); err != nil {
+		t.Error("overwriting a key should only be a warning:", err)
+	}
+
+	dir.Handlers[0].Steps[1].As = "admin"
+	if _, found := findProblem(dir.ValidateDetailed(), "overwritten"); found {
+		t.Error("a key that is not overwritten should not have been warned about")
+	}
+}
+
+func TestDirectiveCopy(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    state:
+      id: "1"
+    steps:
+      - fn: get-user
+        with:
+          id: id
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    steps:
+      - group:
+          - fn: get-user
+`)
+
+	c := dir.Copy()
+	c.AppVersion = "v0.2.0"
+	c.Runnables[0].Name = "get-admin"
+	c.Handlers[0].State["id"] = "2"
+	c.Handlers[0].Steps[0].With["id"] = "other"
+	c.Schedules[0].Steps[0].Group[0].Fn = "get-admin"
+	c.calculateFQFNs()
+
+	if dir.AppVersion != "v0.1.0" || dir.Runnables[0].Name != "get-user" {
+		t.Error("changing the copy changed the original's fields")
+	}
+
+	if dir.Handlers[0].State["id"] != "1" || dir.Handlers[0].Steps[0].With["id"] != "id" {
+		t.Error("changing the copy changed the original's handler")
+	}
+
+	if dir.Schedules[0].Steps[0].Group[0].Fn != "get-user" {
+		t.Error("changing the copy changed the original's schedule")
+	}
+
+	if fqfn, err := dir.FQFN("get-user"); err != nil || fqfn != "default#get-user@v0.1.0" {
+		t.Error("changing the copy changed the original's FQFNs, got", fqfn, err)
+	}
+}
+
+func TestDirectiveCopyConcurrent(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        with:
+          id: id
+`)
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			c := dir.Copy()
+			c.AppVersion = fmt.Sprintf("v0.1.%d", i)
+			c.Handlers[0].Steps[0].With["id"] = fmt.Sprintf("id%d", i)
+			c.calculateFQFNs()
+
+			if _, err := c.Marshal(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if dir.Handlers[0].Steps[0].With["id"] != "id" {
+		t.Error("changing the copies changed the original")
+	}
+}
+
+func TestDirectiveValidatorGroupLastResponse(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-details
+    namespace: default
+  - name: get-prefs
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    response: details
+    steps:
+      - fn: get-user
+        as: user
+      - group:
+          - fn: get-details
+            as: details
+          - fn: get-prefs
+            as: prefs
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a response produced by exactly one member of the last group should be valid:", err)
+	}
+
+	dir.Handlers[0].Response = "user"
+	if _, found := findProblem(dir.ValidateDetailed(), "is not produced by a member of that group"); !found {
+		t.Error("a response produced before the last group should have failed")
+	}
+
+	dir.Handlers[0].Response = "details"
+	dir.Handlers[0].Steps[1].Group[1].As = "details"
+	if _, found := findProblem(dir.ValidateDetailed(), "is produced by more than one member of that group"); !found {
+		t.Error("a response produced by two members of the last group should have failed")
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	aliases, err := ParseWith([]string{"user", "id: userId"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aliases[0] != (Alias{Key: "user", Alias: "user"}) {
+		t.Errorf("a bare entry should use its key as its alias, got %+v", aliases[0])
+	}
+
+	if aliases[1] != (Alias{Key: "userId", Alias: "id"}) {
+		t.Errorf("wrong aliased entry, got %+v", aliases[1])
+	}
+
+	if _, err := ParseWith([]string{"a: b: c"}); err == nil {
+		t.Error("an entry with three parts should have errored")
+	}
+}
+
+func TestFnWithListForms(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    state:
+      userId: "1"
+      token: abc
+    steps:
+      - fn: get-user
+        with:
+          - token
+          - "auth: token"
+          - id: userId
+`)
+
+	with := dir.Handlers[0].Steps[0].With
+	if len(with) != 3 || with["token"] != "token" || with["auth"] != "token" || with["id"] != "userId" {
+		t.Error("the bare, quoted, and unquoted list forms should all be accepted, got", with)
+	}
+
+	dir2 := &Directive{}
+	err := dir2.Unmarshal([]byte(testDirectiveHeader + `
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        with:
+          - "id: userId"
+          - id: other
+`))
+
+	if err == nil || !strings.Contains(err.Error(), "'with' alias id is used more than once") {
+		t.Error("a duplicate alias should have errored, got", err)
+	}
+
+	err = dir2.Unmarshal([]byte(testDirectiveHeader + `
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        with:
+          - id: userId
+            token: token
+`))
+
+	if err == nil || !strings.Contains(err.Error(), "must be a single 'alias: key' pair") {
+		t.Error("a list entry with two pairs should have errored, got", err)
+	}
+}
+
+func TestDirectiveValidatorTimeout(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        timeout: 30
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a timeout within the maximum should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[0].Timeout = -1
+	if _, found := findProblem(dir.ValidateDetailed(), "has negative 'timeout' value at step 0"); !found {
+		t.Error("a negative timeout should have failed")
+	}
+
+	dir.Handlers[0].Steps[0].Timeout = MaxFnTimeout + 1
+	if _, found := findProblem(dir.ValidateDetailed(), "has 'timeout' value at step 0 greater than the maximum"); !found {
+		t.Error("a timeout greater than the maximum should have failed")
+	}
+}
+
+func TestDirectiveValidateFunc(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /a
+    steps:
+      - fn: missing-a
+  - type: request
+    method: GET
+    resource: /b
+    steps:
+      - fn: missing-b
+  - type: request
+    method: GET
+    resource: /c
+    steps:
+      - fn: missing-c
+`)
+
+	all := 0
+	dir.ValidateFunc(func(problem error) bool {
+		all++
+		return true
+	})
+
+	if all < 3 {
+		t.Fatal("expected at least 3 problems, got", all)
+	}
+
+	seen := []error{}
+	dir.ValidateFunc(func(problem error) bool {
+		seen = append(seen, problem)
+		return len(seen) < 2
+	})
+
+	if len(seen) != 2 {
+		t.Error("validation should have stopped after the callback returned false, got", len(seen), "problems")
+	}
+
+	if _, ok := seen[0].(ValidationProblem); !ok {
+		t.Errorf("the callback should receive ValidationProblems, got %T", seen[0])
+	}
+}
+
+func TestDirectiveValidatorDuplicateHandlers(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users
+    steps:
+      - fn: get-user
+  - type: request
+    method: POST
+    resource: /users
+    steps:
+      - fn: get-user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("handlers with different methods for the same resource should be valid:", err)
+	}
+
+	dir.Handlers[1].Input.Method = "GET"
+	if _, found := findProblem(dir.ValidateDetailed(), "duplicate handler for GET /users found"); !found {
+		t.Error("two GET handlers for the same resource should have failed")
+	}
+}
+
+func TestDirectiveMerge(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+secrets:
+  - API_KEY
+middleware:
+  - fn: db#get-user
+    as: user
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: db#get-user
+`)
+
+	other := &Directive{}
+	if err := other.Unmarshal([]byte(`
+identifier: com.suborbital.test
+runnables:
+  - name: send-email
+defaultNamespace: mail
+defaults:
+  retries: 3
+secrets:
+  - API_KEY
+  - SMTP_PASSWORD
+middleware:
+  - fn: send-email
+schedules:
+  - name: digest
+    every:
+      hours: 24
+    steps:
+      - fn: send-email
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dir.Runnables) != 2 || len(dir.Handlers) != 1 || len(dir.Schedules) != 1 {
+		t.Error("wrong number of runnables, handlers, or schedules after merging")
+	}
+
+	if len(dir.Middleware) != 2 || dir.Middleware[1].Fn != "send-email" {
+		t.Error("other's middleware should run after the directive's own, got", dir.Middleware)
+	}
+
+	if strings.Join(dir.Secrets, ",") != "API_KEY,SMTP_PASSWORD" {
+		t.Error("secrets should be combined without duplicates, got", dir.Secrets)
+	}
+
+	if dir.DefaultNamespace != "mail" || dir.Defaults == nil || dir.Defaults.Retries != 3 {
+		t.Error("the defaultNamespace and defaults should be taken from other")
+	}
+
+	if fqfn, err := dir.FQFN("mail#send-email"); err != nil || fqfn != "mail#send-email@v0.1.0" {
+		t.Error("FQFNs should be recalculated after merging, got", fqfn, err)
+	}
+}
+
+func TestDirectiveMergeConflicts(t *testing.T) {
+	conflicts := map[string]*Directive{
+		"identifier":       {Identifier: "com.suborbital.other"},
+		"appVersion":       {AppVersion: "v0.2.0"},
+		"atmoVersion":      {AtmoVersion: "v0.3.0"},
+		"defaultNamespace": {DefaultNamespace: "other"},
+		"defaults":         {Defaults: &Defaults{Retries: 5}},
+		"duplicate fn":     {Runnables: []Runnable{{Name: "get-user", Namespace: "db"}}},
+	}
+
+	for name, other := range conflicts {
+		dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+defaultNamespace: db
+defaults:
+  retries: 3
+`)
+
+		if err := dir.Merge(other); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("merging a conflicting %s should have errored, got %v", name, err)
+		}
+
+		if len(dir.Runnables) != 1 {
+			t.Errorf("a failed merge of a conflicting %s should leave the directive unchanged", name)
+		}
+	}
+}
+
+func TestDirectiveValidatorScheduleEvery(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: report
+    namespace: default
+schedules:
+  - name: report
+    every:
+      hours: 1
+      minutes: -5
+    steps:
+      - fn: report
+`)
+
+	if _, found := findProblem(dir.ValidateDetailed(), "schedule report has negative 'every' values"); !found {
+		t.Error("a schedule with a negative 'every' value should have failed")
+	}
+
+	dir.Schedules[0].Every = ScheduleEvery{}
+	if _, found := findProblem(dir.ValidateDetailed(), "schedule report has no 'every' or 'cron' values"); !found {
+		t.Error("a schedule with all-zero 'every' values should have failed")
+	}
+
+	dir.Schedules[0].Every = ScheduleEvery{Days: 1 << 60}
+	if _, found := findProblem(dir.ValidateDetailed(), "schedule report has an invalid 'every' value"); !found {
+		t.Error("a schedule with an absurd 'every' value should have failed")
+	}
+
+	dir.Schedules[0].Every = ScheduleEvery{Minutes: 5}
+	if err := dir.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirectiveValidatorHandlerState(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: list-users
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users
+    state:
+      pageSize: "20"
+    steps:
+      - fn: list-users
+        with:
+          limit: pageSize
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a step referencing a key from the handler's state should be valid:", err)
+	}
+
+	dir.Handlers[0].State = nil
+	if _, found := findProblem(dir.ValidateDetailed(), "not yet available in the handler's state: pageSize"); !found {
+		t.Error("a step referencing a key that isn't in the handler's state should have failed")
+	}
+}
+
+func TestDirectiveFQFNPrefix(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+  - name: send-email
+    namespace: default
+`)
+
+	dir.SetFQFNPrefix("registry.example.com/")
+
+	fqfn, err := dir.FQFN("db#get-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fqfn != "registry.example.com/db#get-user@v0.1.0" {
+		t.Error("the FQFN should have the prefix, got", fqfn)
+	}
+
+	if fqfn, _ := dir.FQFN("send-email"); fqfn != "registry.example.com/default#send-email@v0.1.0" {
+		t.Error("the FQFN of a default namespace fn should have the prefix, got", fqfn)
+	}
+
+	namespace, fn, version, err := dir.ParseFQFN("registry.example.com/default#send-email@v0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if namespace != "default" || fn != "send-email" || version != "v0.1.0" {
+		t.Error("ParseFQFN should strip the directive's prefix, got", namespace, fn, version)
+	}
+
+	if _, _, _, err := dir.ParseFQFN("default#send-email@v0.1.0"); err == nil {
+		t.Error("a FQFN without the directive's prefix should have errored")
+	}
+
+	dir.SetFQFNPrefix("")
+	if fqfn, _ := dir.FQFN("db#get-user"); fqfn != "db#get-user@v0.1.0" {
+		t.Error("clearing the prefix should restore the default format, got", fqfn)
+	}
+}
+
+func TestDirectiveValidatorGroupDuplicateAlias(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-admin
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    response: user
+    steps:
+      - group:
+          - fn: get-user
+            as: user
+          - fn: get-admin
+            as: admin
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a group with unique aliases should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[0].Group[1].As = "user"
+	if _, found := findProblem(dir.ValidateDetailed(), "group at step 0 for handler GET /user has duplicate alias user"); !found {
+		t.Error("a group with a duplicate alias should have failed")
+	}
+
+	dir.Handlers[0].Steps[0].Group[1] = CallableFn{Fn: "get-user"}
+	dir.Handlers[0].Steps[0].Group[0].As = ""
+	if _, found := findProblem(dir.ValidateDetailed(), "has duplicate alias get-user"); !found {
+		t.Error("a group calling the same fn twice without aliases should have failed")
+	}
+}
+
+func TestDirectiveFunctions(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: a
+    namespace: default
+  - name: b
+    namespace: default
+  - name: c
+    namespace: default
+  - name: d
+    namespace: default
+  - name: e
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: a
+        as: items
+      - group:
+          - fn: b
+          - fn: c
+      - forEach:
+          in: items
+          fn: d
+          as: results
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    steps:
+      - fn: e
+`)
+
+	fns := []string{}
+	for _, fn := range dir.Functions() {
+		fns = append(fns, fn.Fn)
+	}
+
+	if strings.Join(fns, ",") != "a,b,c,d,e" {
+		t.Error("wrong fns or order, got", fns)
+	}
+}
+
+func TestDirectiveValidatorRetry(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        onErr:
+          code:
+            503: retry
+          other: return
+          retries: 3
+          backoffMs: 100
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a retry with a positive count should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[0].OnErr.Retries = 0
+	if _, found := findProblem(dir.ValidateDetailed(), "uses the 'retry' error directive at step 0 without a positive 'onErr.retries' value"); !found {
+		t.Error("a retry without a count should have failed")
+	}
+
+	dir.Handlers[0].Steps[0].OnErr.Retries = -1
+	if _, found := findProblem(dir.ValidateDetailed(), "has negative 'onErr.retries' or 'onErr.backoffMs' value at step 0"); !found {
+		t.Error("a negative retry count should have failed")
+	}
+
+	dir.Handlers[0].Steps[0].OnErr = &FnOnErr{Any: "retry", Other: "return", Retries: 3}
+	if _, found := findProblem(dir.ValidateDetailed(), "has 'onErr.other' value at step 0 while specific codes are not specified"); !found {
+		t.Error("'any' and 'other' should still be mutually exclusive with a retry")
+	}
+}
+
+func TestDirectiveValidatorIdentifierPrefix(t *testing.T) {
+	dir := testDirective(t, `
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: missing
+`)
+
+	err := dir.Validate()
+	if err == nil {
+		t.Fatal("directive validation should have failed")
+	}
+
+	if !strings.Contains(err.Error(), "com.suborbital.test@v0.1.0: handler for GET /user") {
+		t.Error("the problems should be prefixed with the directive's identifier and version, got", err)
+	}
+
+	dir.Identifier = ""
+	if err := dir.Validate(); err == nil || !strings.Contains(err.Error(), "unnamed directive: ") {
+		t.Error("the problems of a directive without an identifier should be prefixed with 'unnamed directive', got", err)
+	}
+}
+
+func TestDirectiveValidatorForEachReturn(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: list-users
+    namespace: default
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users
+    response: details
+    steps:
+      - fn: list-users
+        as: users
+      - forEach:
+          in: users
+          fn: get-user
+          as: details
+          onErr:
+            any: return
+`)
+
+	if _, found := findProblem(dir.ValidateDetailed(), "uses the 'return' error directive, use 'continue' instead"); !found {
+		t.Error("a ForEach with a 'return' error directive should have failed")
+	}
+
+	dir.Handlers[0].Steps[1].ForEach.OnErr.Any = "continue"
+	if err := dir.Validate(); err != nil {
+		t.Error("a ForEach with a 'continue' error directive should be valid:", err)
+	}
+}
+
+func TestDirectiveUsedRunnables(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+  - name: send-email
+    namespace: mail
+  - name: unused
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - group:
+          - fn: db#get-user
+schedules:
+  - name: digest
+    every:
+      hours: 24
+    steps:
+      - fn: mail#send-email
+`)
+
+	used := dir.UsedRunnables()
+
+	if len(used) != 2 || !used["db#get-user@v0.1.0"] || !used["mail#send-email@v0.1.0"] {
+		t.Error("wrong used runnables, got", used)
+	}
+
+	if used["default#unused@v0.1.0"] {
+		t.Error("an unreferenced runnable should not be used")
+	}
+}
+
+func TestDirectiveValidateStrictUnusedRunnables(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: library-fn
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("an unused runnable should not fail Validate:", err)
+	}
+
+	err := dir.ValidateStrict()
+	if err == nil {
+		t.Fatal("an unused runnable should fail ValidateStrict")
+	}
+
+	if !strings.Contains(err.Error(), "fn default#library-fn is not used by any handler or schedule") {
+		t.Error("wrong problem for an unused runnable, got", err)
+	}
+
+	if strings.Contains(err.Error(), "get-user") {
+		t.Error("a used runnable should not be reported, got", err)
+	}
+}
+
+func TestParseWithSharedInput(t *testing.T) {
+	shared := []string{"user", "id: userId"}
+
+	first, err := ParseWith(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := ParseWith(shared[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first[0].Key = "changed"
+
+	if second[0].Key != "user" || shared[0] != "user" {
+		t.Error("parsing a shared slice should give independent results and leave the input unchanged")
+	}
+}
+
+func TestDirectiveYAMLAnchors(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-admin
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    state:
+      id: "1"
+    steps:
+      - fn: get-user
+        with: &ids
+          - "userId: id"
+      - fn: get-admin
+        with: *ids
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir.Handlers[0].Steps[0].With["userId"] = "other"
+
+	if dir.Handlers[0].Steps[1].With["userId"] != "id" {
+		t.Error("steps sharing a 'with' value through an anchor should not share state")
+	}
+}
+
+func TestCallableFnValidate(t *testing.T) {
+	knownFns := map[string]bool{"get-user": true}
+	available := map[string]bool{"id": true}
+
+	fn := CallableFn{Fn: "get-user", With: FnWith{"userId": "id"}}
+	if errs := fn.Validate(available, knownFns); len(errs) != 0 {
+		t.Error("a valid fn should have no errors, got", errs)
+	}
+
+	unknown := CallableFn{Fn: "get-admin"}
+	if errs := unknown.Validate(available, knownFns); len(errs) != 1 || !strings.Contains(errs[0].Error(), "fn does not exist: get-admin") {
+		t.Error("an unknown fn should have errored, got", errs)
+	}
+
+	badAlias := CallableFn{Fn: "get-user", With: FnWith{"user id": "id"}}
+	if errs := badAlias.Validate(available, knownFns); len(errs) != 1 || !strings.Contains(errs[0].Error(), "is not a valid identifier") {
+		t.Error("a bad 'with' alias should have errored, got", errs)
+	}
+
+	forward := CallableFn{Fn: "get-user", With: FnWith{"user": "user"}}
+	if errs := forward.Validate(available, knownFns); len(errs) != 1 || !strings.Contains(errs[0].Error(), "not yet available in the state: user") {
+		t.Error("a forward-referenced state key should have errored, got", errs)
+	}
+
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-admin
+        with:
+          id: userId
+        timeout: -1
+        onErr:
+          code:
+            404: explode
+          other: ignore
+`)
+
+	// Validate describes the fn's problems as part of the handler, with the same messages that it always has
+	expected := []string{
+		"handler for GET /user lists fn at step 0 that does not exist: get-admin (did you forget a namespace?)",
+		"handler for GET /user has 'with' value at step 0 referencing a key that is not yet available in the handler's state: userId",
+		"handler for GET /user has negative 'timeout' value at step 0",
+		"handler for GET /user has 'onErr.any' value at step 0 with an invalid error directive: ignore",
+		"handler for GET /user has 'onErr.code' value at step 0 with an invalid error directive for code 404: explode",
+	}
+
+	problems := dir.ValidateDetailed()
+	for _, message := range expected {
+		found := false
+		for _, p := range problems {
+			if p.Message == message {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected the problem %q, got %v", message, problems)
+		}
+	}
+}
+
+func TestDirectiveValidatorDottedResponse(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: audit
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    response: result.body
+    steps:
+      - fn: get-user
+        as: result
+      - fn: audit
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a dotted response whose root is produced should be valid:", err)
+	}
+
+	dir.Handlers[0].Response = "reslut.body"
+	if _, found := findProblem(dir.ValidateDetailed(), "lists response state key that does not exist: reslut.body"); !found {
+		t.Error("a dotted response whose root is never produced should have failed")
+	}
+}
+
+func TestDirectiveHash(t *testing.T) {
+	dir := testDirective(t, `
+# the user service
+runnables:
+  - name: get-user
+    namespace: db
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: db#get-user
+        with:
+          id: userId
+          token: authToken
+`)
+
+	reordered := testDirective(t, `
+handlers:
+  - resource: /user
+    method: GET
+    type: request
+    steps:
+      - with:
+          token: authToken
+          id: userId
+        fn: db#get-user
+runnables:
+  - namespace: db
+    name: get-user
+`)
+
+	hash, err := dir.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reorderedHash, err := reordered.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash != reorderedHash {
+		t.Error("directives that differ only in comments and key order should have the same hash")
+	}
+
+	reordered.Handlers[0].Input.Resource = "/users"
+
+	changedHash, err := reordered.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash == changedHash {
+		t.Error("directives with different contents should have different hashes")
+	}
+}
+
+func TestDirectiveMethodNormalization(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: get
+    resource: /user
+    steps:
+      - fn: get-user
+`)
+
+	if dir.Handlers[0].Input.Method != "GET" {
+		t.Error("the method should be normalized to uppercase, got", dir.Handlers[0].Input.Method)
+	}
+
+	if err := dir.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	dir.Handlers[0].Input.Method = "FETCH"
+	if _, found := findProblem(dir.ValidateDetailed(), "has an invalid method FETCH"); !found {
+		t.Error("an unknown method should have failed")
+	}
+}
+
+func TestDirectiveValidatorHeaders(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    headers:
+      Content-Type: application/json
+      Cache-Control: no-cache
+    steps:
+      - fn: get-user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("valid headers should be valid:", err)
+	}
+
+	dir.Handlers[0].Headers["Content-Type"] = "text/plain\r\nSet-Cookie: session=1"
+	if _, found := findProblem(dir.ValidateDetailed(), `response header "Content-Type" containing a CR or LF character`); !found {
+		t.Error("a header value containing a newline should have failed")
+	}
+
+	dir.Handlers[0].Headers = map[string]string{"": "value"}
+	if _, found := findProblem(dir.ValidateDetailed(), "response header with an empty name"); !found {
+		t.Error("a header with an empty name should have failed")
+	}
+}
+
+func TestValidationErrorAs(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: missing-a
+      - fn: missing-b
+`)
+
+	err := dir.Validate()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Validate should return a *ValidationError, got %T", err)
+	}
+
+	if len(validationErr.Problems) != 2 {
+		t.Error("expected 2 problems, got", validationErr.Problems)
+	}
+
+	var problem ValidationProblem
+	if !errors.As(err, &problem) || problem.StepIndex != 0 {
+		t.Error("errors.As should find the individual problems, got", problem)
+	}
+
+	if !errors.Is(err, validationErr.Problems[1]) {
+		t.Error("errors.Is should find the individual problems")
+	}
+
+	// toolchains before Go 1.20 don't traverse Unwrap() []error, and rely on these
+	var direct ValidationProblem
+	if !validationErr.As(&direct) || direct.StepIndex != 0 {
+		t.Error("As should find the individual problems, got", direct)
+	}
+
+	if !validationErr.Is(validationErr.Problems[1]) || validationErr.Is(errors.New("missing-a")) {
+		t.Error("Is should only match the individual problems")
+	}
+
+	if !strings.HasPrefix(err.Error(), "found 2 problems:") {
+		t.Error("the error should keep the existing format, got", err)
+	}
+}
+
+func TestDirectiveValidatorWhen(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: notify
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        as: user
+      - fn: notify
+        when: user.status == "active"
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a condition referencing produced state should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[1].When = "account.status == 200"
+	if _, found := findProblem(dir.ValidateDetailed(), "has 'when' value at step 1 referencing a key that is not yet available in the handler's state: account"); !found {
+		t.Error("a condition referencing an unknown key should have failed")
+	}
+
+	dir.Handlers[0].Steps[1].When = "user.status"
+	if _, found := findProblem(dir.ValidateDetailed(), "has an invalid 'when' value at step 1"); !found {
+		t.Error("a condition without a comparison should have failed")
+	}
+}
+
+func TestDirectiveLookups(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+  - type: stream
+    resource: events
+    steps:
+      - fn: get-user
+  - type: request
+    method: GET
+    resource: users/
+    steps:
+      - fn: get-user
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    steps:
+      - fn: get-user
+`)
+
+	h, found := dir.HandlerFor("get", "/user")
+	if !found || h.Input.Method != "GET" {
+		t.Error("the handler for GET /user should have been found")
+	}
+
+	h.Description = "changed"
+	if dir.Handlers[0].Description != "changed" {
+		t.Error("HandlerFor should return a pointer into the directive's handlers")
+	}
+
+	if h, found := dir.HandlerFor("", "events"); !found || h.Input.Type != InputTypeStream {
+		t.Error("an empty method should find the stream handler")
+	}
+
+	if _, found := dir.HandlerFor("POST", "/user"); found {
+		t.Error("a handler for POST /user should not have been found")
+	}
+
+	for _, resource := range []string{"users", "/users", "/users/", "//users"} {
+		if h, found := dir.HandlerFor("GET", resource); !found || h != &dir.Handlers[2] {
+			t.Errorf("the handler declared as 'users/' should have been found for %q", resource)
+		}
+	}
+
+	if _, found := dir.HandlerFor("", "/events"); found {
+		t.Error("stream resources should not be normalized when looking them up")
+	}
+
+	s, found := dir.ScheduleByName("refresh")
+	if !found || s != &dir.Schedules[0] {
+		t.Error("ScheduleByName should return a pointer to the refresh schedule")
+	}
+
+	if _, found := dir.ScheduleByName("missing"); found {
+		t.Error("a missing schedule should not have been found")
+	}
+}
+
+func TestDirectiveValidatorScheduleStateShadow(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: fetch
+    namespace: default
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    state:
+      url: https://example.com
+    steps:
+      - fn: fetch
+        as: url
+        with:
+          url: url
+`)
+
+	p, found := findProblem(dir.ValidateDetailed(), "schedule refresh has step 0 producing url, which overwrites the url key from the schedule's initial state")
+	if !found {
+		t.Fatal("a step overwriting the schedule's initial state should have been warned about")
+	}
+
+	if p.Severity != SeverityWarning {
+		t.Error("overwriting the schedule's initial state should only be a warning")
+	}
+
+	dir.Schedules[0].Steps[0].As = "page"
+	if _, found := findProblem(dir.ValidateDetailed(), "initial state"); found {
+		t.Error("a step not overwriting the schedule's initial state should not have been warned about")
+	}
+}
+
+func TestWithEntriesRoundTrip(t *testing.T) {
+	aliases := []Alias{
+		{Key: "user", Alias: "user"},
+		{Key: "userId", Alias: "id"},
+	}
+
+	entries := WithEntries(aliases)
+	if strings.Join(entries, ",") != "user,id: userId" {
+		t.Error("wrong entries, got", entries)
+	}
+
+	parsed, err := ParseWith(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed) != 2 || parsed[0] != aliases[0] || parsed[1] != aliases[1] {
+		t.Error("aliases should survive the round trip, got", parsed)
+	}
+
+	fn := CallableFn{Fn: "get-user"}
+	fn.SetWith(parsed)
+
+	if got := fn.With.Aliases(); len(got) != 2 || got[0] != aliases[1] || got[1] != aliases[0] {
+		t.Error("SetWith should set the fn's 'with' value, got", got)
+	}
+}
+
+func TestDirectiveValidatorForEachParallelism(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: list-users
+    namespace: default
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users
+    response: details
+    steps:
+      - fn: list-users
+        as: users
+      - forEach:
+          in: users
+          fn: get-user
+          as: details
+          parallelism: 4
+`)
+
+	for _, parallelism := range []int{0, 1, 4} {
+		dir.Handlers[0].Steps[1].ForEach.Parallelism = parallelism
+		if err := dir.Validate(); err != nil {
+			t.Errorf("a parallelism of %d should be valid: %s", parallelism, err)
+		}
+	}
+
+	dir.Handlers[0].Steps[1].ForEach.Parallelism = -1
+	if _, found := findProblem(dir.ValidateDetailed(), "has negative 'parallelism' value"); !found {
+		t.Error("a negative parallelism should have failed")
+	}
+}
+
+func TestDirectiveValidateStrictContinuingResponse(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    response: user
+    steps:
+      - fn: get-user
+        as: user
+        onErr:
+          any: continue
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a response produced by a fn that continues on error should only fail ValidateStrict:", err)
+	}
+
+	err := dir.ValidateStrict()
+	if err == nil || !strings.Contains(err.Error(), "continues on error, so the response would be empty when it fails") {
+		t.Error("a response produced by a fn that continues on error should have failed ValidateStrict, got", err)
+	}
+
+	dir.Handlers[0].Steps[0].OnErr.Any = "return"
+	if err := dir.ValidateStrict(); err != nil {
+		t.Error("a response produced by a fn that returns on error should be valid:", err)
+	}
+}
+
+func TestDirectiveBuilders(t *testing.T) {
+	dir := &Directive{Identifier: "com.suborbital.test", AppVersion: "v0.1.0", AtmoVersion: "v0.2.0"}
+
+	if _, err := dir.FQFN("db#get-user"); err == nil {
+		t.Fatal("an undeclared fn should have errored")
+	}
+
+	dir.AddRunnable("db", "get-user").
+		AddHandler(Handler{
+			Input: Input{Type: InputTypeRequest, Method: "GET", Resource: "/user"},
+			Steps: []Executable{{CallableFn: CallableFn{Fn: "db#get-user"}}},
+		}).
+		AddSchedule(Schedule{
+			Name:  "refresh",
+			Every: ScheduleEvery{Minutes: 5},
+			Steps: []Executable{{CallableFn: CallableFn{Fn: "db#get-user"}}},
+		})
+
+	if err := dir.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fqfn, err := dir.FQFN("db#get-user"); err != nil || fqfn != "db#get-user@v0.1.0" {
+		t.Error("adding a runnable should make its FQFN available, got", fqfn, err)
+	}
+
+	yamlBytes, err := dir.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir2 := &Directive{}
+	if err := dir2.Unmarshal(yamlBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dir2.Runnables) != 1 || len(dir2.Handlers) != 1 || len(dir2.Schedules) != 1 {
+		t.Error("the built directive did not survive marshalling")
+	}
+}
+
+func TestDirectiveValidatorProblemLines(t *testing.T) {
+	dir := &Directive{}
+	err := dir.Unmarshal([]byte(`identifier: com.suborbital.test
+appVersion: v0.1.0
+atmoVersion: v0.2.0
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+      - fn: missing
+  - type: request
+    resource: /admin
+    steps:
+      - fn: get-user
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := dir.ValidateDetailed()
+
+	lines := map[string]int{
+		"duplicate fn default#get-user":                   7,
+		"lists fn at step 1 that does not exist: missing": 15,
+		"does not specify a method":                       16,
+	}
+
+	for message, line := range lines {
+		p, found := findProblem(problems, message)
+		if !found {
+			t.Errorf("expected a problem containing %q", message)
+		} else if p.Line != line {
+			t.Errorf("the problem %q should be on line %d, got %d", p.Message, line, p.Line)
+		}
+	}
+
+	dir.Handlers[0].Steps[1].Fn = "get-user"
+	dir.positions = nil
+
+	if p, found := findProblem(dir.ValidateDetailed(), "does not specify a method"); !found || p.Line != 0 {
+		t.Error("a problem should have no line when the positions are not known, got", p)
+	}
+}
+
+func TestDirectiveRunnableVersion(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: auth
+    namespace: shared
+    version: v1.2.0
+  - name: get-user
+    namespace: default
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fqfn, _ := dir.FQFN("shared#auth"); fqfn != "shared#auth@v1.2.0" {
+		t.Error("a pinned runnable should use its own version, got", fqfn)
+	}
+
+	if fqfn, _ := dir.FQFN("get-user"); fqfn != "default#get-user@v0.1.0" {
+		t.Error("an unpinned runnable should use the app version, got", fqfn)
+	}
+
+	dir.Runnables[0].Version = "1.2"
+	if _, found := findProblem(dir.ValidateDetailed(), "function shared#auth has a version that is not a valid semantic version"); !found {
+		t.Error("an invalid pinned version should have failed")
+	}
+}
+
+func TestDirectiveValidatorStepLimits(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    response: c
+    steps:
+      - fn: get-user
+        as: a
+      - fn: get-user
+        as: b
+      - group:
+          - fn: get-user
+            as: c
+          - fn: get-user
+            as: d
+`)
+
+	if err := dir.ValidateWithOptions(ValidateOptions{MaxSteps: 3}); err != nil {
+		t.Error("a handler at the maximum number of steps should be valid:", err)
+	}
+
+	err := dir.ValidateWithOptions(ValidateOptions{MaxSteps: 2})
+	if err == nil || !strings.Contains(err.Error(), "has 3 steps, more than the maximum of 2") {
+		t.Error("a handler with too many steps should have failed, got", err)
+	}
+
+	if err := dir.ValidateWithOptions(ValidateOptions{MaxStepFns: 4}); err != nil {
+		t.Error("a handler at the maximum number of fns should be valid:", err)
+	}
+
+	if _, found := findProblem(dir.validate(nil, ValidateOptions{MaxStepFns: 3}).list, "calls 4 fns, more than the maximum of 3"); !found {
+		t.Error("a handler calling too many fns (including group members) should have failed")
+	}
+
+	if err := dir.ValidateWithOptions(ValidateOptions{MaxDepth: 2}); err != nil {
+		t.Error("a group at the maximum depth should be valid:", err)
+	}
+
+	problem, found := findProblem(dir.validate(nil, ValidateOptions{MaxDepth: 1}).list, "has step 2 nested 2 levels deep, more than the maximum of 1")
+	if !found || problem.StepIndex != 2 {
+		t.Error("a group deeper than the maximum depth should have failed")
+	}
+
+	if err := dir.Validate(); err != nil {
+		t.Error("the default limits should allow the handler:", err)
+	}
+}
+
+func TestDirectiveUnmarshalStepLimits(t *testing.T) {
+	steps := strings.Repeat(`
+      - fn: get-user`, DefaultMaxStepFns+1)
+
+	in := []byte(testDirectiveHeader + `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:` + steps + "\n")
+
+	dir := &Directive{}
+	if err := dir.Unmarshal(in); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("calls %d fns, more than the maximum of %d", DefaultMaxStepFns+1, DefaultMaxStepFns)) {
+		t.Error("unmarshalling a handler with more than the default number of fns should have failed, got", err)
+	}
+
+	if _, err := UnmarshalAll(in); err == nil || !strings.Contains(err.Error(), "failed to unmarshal document 0") {
+		t.Error("UnmarshalAll should have failed, got", err)
+	}
+
+	if err := dir.Decode(strings.NewReader(string(in))); err == nil {
+		t.Error("Decode should have failed")
+	}
+}
+
+func TestDirectiveDiff(t *testing.T) {
+	old := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: report
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+  - type: request
+    method: DELETE
+    resource: /user
+    steps:
+      - fn: get-user
+schedules:
+  - name: report
+    every:
+      hours: 1
+    steps:
+      - fn: report
+`)
+
+	updated := old.Copy()
+	updated.AddRunnable("default", "send-email")
+	updated.Handlers = updated.Handlers[:1]
+	updated.Schedules[0].Every.Hours = 2
+
+	diff := old.Diff(updated)
+
+	if strings.Join(diff.AddedRunnables, ",") != "default#send-email" || len(diff.RemovedRunnables) != 0 || len(diff.ChangedRunnables) != 0 {
+		t.Errorf("wrong runnable changes: %+v", diff)
+	}
+
+	if strings.Join(diff.RemovedHandlers, ",") != "DELETE /user" || len(diff.AddedHandlers) != 0 || len(diff.ChangedHandlers) != 0 {
+		t.Errorf("wrong handler changes: %+v", diff)
+	}
+
+	if strings.Join(diff.ChangedSchedules, ",") != "report" || len(diff.AddedSchedules) != 0 || len(diff.RemovedSchedules) != 0 {
+		t.Errorf("wrong schedule changes: %+v", diff)
+	}
+
+	if empty := old.Diff(old.Copy()); len(empty.AddedRunnables)+len(empty.ChangedHandlers)+len(empty.ChangedSchedules) != 0 {
+		t.Errorf("a directive should not differ from its copy: %+v", empty)
+	}
+}
+
+func TestDirectiveValidatorOnErrCodes(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        onErr:
+          code:
+            404: continue
+          other: return
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a 404 code should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[0].OnErr.Code = map[int]string{9999: "continue"}
+	if _, found := findProblem(dir.ValidateDetailed(), "has 'onErr.code' key 9999 at step 0 that is not a valid status code"); !found {
+		t.Error("a 9999 code should have failed")
+	}
+}
+
+func TestDirectiveValidatorStepResponse(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-cached
+    namespace: default
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-cached
+        as: cached
+        response: cached
+      - fn: get-user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a step responding with a key it produces should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[0].Response = "user"
+	if _, found := findProblem(dir.ValidateDetailed(), "step at position 0 for handler GET /user lists response state key that does not exist: user"); !found {
+		t.Error("a step responding with a key that isn't available should have failed")
+	}
+
+	dir.Schedules = []Schedule{{Name: "refresh", Every: ScheduleEvery{Minutes: 5}, Steps: dir.Handlers[0].Steps}}
+	if _, found := findProblem(dir.ValidateDetailed(), "has a 'response' value, which is only allowed in handlers"); !found {
+		t.Error("a schedule step with a response should have failed")
+	}
+}
+
+func TestScheduleNumberOfSecondsChecked(t *testing.T) {
+	s := Schedule{Every: ScheduleEvery{Days: 1, Hours: 1, Minutes: 1, Seconds: 1}}
+
+	seconds, err := s.NumberOfSecondsChecked()
+	if err != nil || seconds != 90061 {
+		t.Error("wrong number of seconds, got", seconds, err)
+	}
+
+	s.Every = ScheduleEvery{Seconds: int(maxDurationSeconds)}
+	if _, err := s.NumberOfSecondsChecked(); err != nil {
+		t.Error("the largest interval that fits in a time.Duration should not have errored:", err)
+	}
+
+	s.Every.Seconds++
+	if _, err := s.NumberOfSecondsChecked(); err == nil {
+		t.Error("an interval that doesn't fit in a time.Duration should have errored")
+	}
+
+	s.Every = ScheduleEvery{Days: maxInt/86400 + 1}
+	if _, err := s.NumberOfSecondsChecked(); err == nil {
+		t.Error("a number of days that overflows should have errored")
+	}
+
+	s.Every = ScheduleEvery{Seconds: maxInt, Minutes: 1}
+	if _, err := s.NumberOfSecondsChecked(); err == nil {
+		t.Error("a sum that overflows should have errored")
+	}
+}
+
+func TestDirectiveValidatorForEachAsIn(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: list-items
+    namespace: default
+  - name: process
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /items
+    steps:
+      - fn: list-items
+        as: items
+      - forEach:
+          in: items
+          fn: process
+          as: items
+`)
+
+	if _, found := findProblem(dir.ValidateDetailed(), "has the same 'as' and 'in' value items"); !found {
+		t.Error("a ForEach with the same 'as' and 'in' value should have failed")
+	}
+}
+
+func TestCallableFnString(t *testing.T) {
+	cases := []struct {
+		fn       CallableFn
+		expected string
+	}{
+		{CallableFn{Fn: "db#get-user"}, "db#get-user"},
+		{CallableFn{Fn: "db#get-user", As: "user"}, "db#get-user as user"},
+		{CallableFn{Fn: "db#get-user", With: FnWith{"token": "token", "id": "userId"}}, "db#get-user(id: userId, token)"},
+		{CallableFn{Fn: "auth#verify", With: FnWith{"token": "authToken"}, As: "user"}, "auth#verify(token: authToken) as user"},
+	}
+
+	for _, c := range cases {
+		if str := c.fn.String(); str != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, str)
+		}
+	}
+}
+
+func TestExecutableString(t *testing.T) {
+	group := Executable{
+		Group:    []CallableFn{{Fn: "db#get-user"}, {Fn: "db#get-details", As: "details"}},
+		Response: "details",
+	}
+
+	if str := group.String(); str != "group[db#get-user, db#get-details as details] -> details" {
+		t.Error("wrong group string, got", str)
+	}
+
+	forEach := Executable{ForEach: &ForEach{In: "items", Fn: "process", As: "results"}}
+
+	if str := forEach.String(); str != "forEach(items) process as results" {
+		t.Error("wrong ForEach string, got", str)
+	}
+}
+
+func TestDirectiveDescriptions(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+    description: Fetches a user from the database
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    description: Returns the current user
+    steps:
+      - fn: get-user
+schedules:
+  - name: refresh
+    description: Refreshes the user cache
+    every:
+      minutes: 5
+    steps:
+      - fn: get-user
+`)
+
+	yamlBytes, err := dir.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir2 := &Directive{}
+	if err := dir2.Unmarshal(yamlBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir2.Runnables[0].Description != "Fetches a user from the database" ||
+		dir2.Handlers[0].Description != "Returns the current user" ||
+		dir2.Schedules[0].Description != "Refreshes the user cache" {
+		t.Error("descriptions did not survive the round trip")
+	}
+
+	if err := dir2.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	dir2.Handlers[0].Description = strings.Repeat("a", MaxDescriptionLength+1)
+	if _, found := findProblem(dir2.ValidateDetailed(), "has a description longer than the maximum"); !found {
+		t.Error("a description longer than the maximum should have failed")
+	}
+}
+
+func TestDirectiveToOpenAPI(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: record
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users/:id
+    description: Returns a user
+    steps:
+      - fn: get-user
+  - type: request
+    method: POST
+    resource: /users
+    description: Creates a user
+    steps:
+      - fn: get-user
+  - type: stream
+    resource: events
+    steps:
+      - fn: record
+`)
+
+	doc, err := dir.ToOpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden, err := ioutil.ReadFile("testdata/openapi.golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(doc) != strings.TrimSpace(string(golden)) {
+		t.Errorf("generated document does not match testdata/openapi.golden.json, got:\n%s", doc)
+	}
+}
+
+func TestDirectiveValidatorResourceParams(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-post
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users/:id/posts/:postId
+    steps:
+      - fn: get-post
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a resource with unique path params should be valid:", err)
+	}
+
+	cases := map[string]string{
+		"/users/:id/:id": "path param id is used more than once",
+		"/users/:/posts": `path param ":" is not a valid name`,
+		"/users/:1st":    `path param ":1st" is not a valid name`,
+		"/users//posts":  "empty",
+	}
+
+	for resource, message := range cases {
+		dir.Handlers[0].Input.Resource = resource
+		if _, found := findProblem(dir.ValidateDetailed(), message); !found {
+			t.Errorf("the resource %s should have failed with %q, got %v", resource, message, dir.ValidateDetailed())
+		}
+	}
+}
+
+func TestHandlerRequiredInputKeys(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-posts
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    state:
+      pageSize: "20"
+    steps:
+      - fn: get-user
+        as: user
+        with:
+          id: userId
+      - fn: get-posts
+        with:
+          user: user
+          limit: pageSize
+          sort: sortOrder
+`)
+
+	keys := dir.Handlers[0].RequiredInputKeys()
+	if strings.Join(keys, ",") != "sortOrder,userId" {
+		t.Error("wrong required input keys, got", keys)
+	}
+}
+
+func TestDirectiveCompactRoundTrip(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        as: user
+        with:
+          id: userId
+        onErr:
+          code:
+            404: continue
+          other: return
+    state:
+      userId: "1"
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    steps:
+      - fn: get-user
+`)
+
+	compact, err := dir.MarshalCompact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(compact), "\n") {
+		t.Error("the compact form should be a single line, got", string(compact))
+	}
+
+	for name, unmarshal := range map[string]func(*Directive, []byte) error{
+		"UnmarshalCompact": (*Directive).UnmarshalCompact,
+		"Unmarshal":        (*Directive).Unmarshal,
+	} {
+		dir2 := &Directive{}
+		if err := unmarshal(dir2, compact); err != nil {
+			t.Errorf("%s failed to read the compact form: %s", name, err)
+			continue
+		}
+
+		if err := dir2.Validate(); err != nil {
+			t.Error(name, err)
+		}
+
+		step := dir2.Handlers[0].Steps[0]
+		if step.Fn != "get-user" || step.With["id"] != "userId" || step.OnErr.Code[404] != "continue" || dir2.Handlers[0].Input.Method != "GET" {
+			t.Errorf("%s did not round trip the handler: %+v", name, dir2.Handlers[0])
+		}
+
+		if dir2.Schedules[0].Every.Minutes != 5 {
+			t.Errorf("%s did not round trip the schedule", name)
+		}
+	}
+}
+
+func TestDirectiveValidatorGroupSiblingReference(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-details
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    response: details
+    state:
+      id: "1"
+    steps:
+      - group:
+          - fn: get-user
+            as: user
+            with:
+              id: id
+          - fn: get-details
+            as: details
+            with:
+              user: user
+`)
+
+	if _, found := findProblem(dir.ValidateDetailed(), "group at step 0 for handler GET /user has fn get-details referencing user, which is produced by another member of the same group"); !found {
+		t.Error("a group member referencing a sibling's output should have failed")
+	}
+
+	dir.Handlers[0].Steps[0].Group[1].With = FnWith{"id": "id"}
+	if err := dir.Validate(); err != nil {
+		t.Error("group members referencing state from before the group should be valid:", err)
+	}
+}
+
+func TestDirectiveValidatorMaxSteps(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: step
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: step
+      - fn: step
+      - fn: step
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    steps:
+      - fn: step
+      - fn: step
+      - fn: step
+`)
+
+	for _, max := range []int{0, 3, 4} {
+		if err := dir.ValidateWithOptions(ValidateOptions{MaxSteps: max}); err != nil {
+			t.Errorf("a maximum of %d steps should be valid: %s", max, err)
+		}
+	}
+
+	problems := dir.validate(nil, ValidateOptions{MaxSteps: 2}).list
+	if _, found := findProblem(problems, "handler for GET /user has 3 steps, more than the maximum of 2"); !found {
+		t.Error("a handler above the maximum number of steps should have failed")
+	}
+
+	if _, found := findProblem(problems, "schedule for refresh has 3 steps, more than the maximum of 2"); !found {
+		t.Error("a schedule above the maximum number of steps should have failed")
+	}
+}
+
+func TestDirectiveDefaultNamespace(t *testing.T) {
+	dir := testDirective(t, `
+defaultNamespace: users
+runnables:
+  - name: get-user
+  - name: send-email
+    namespace: mail
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: users#get-user
+      - fn: mail#send-email
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir.Runnables[0].Namespace != "users" {
+		t.Error("a runnable without a namespace should inherit the defaultNamespace, got", dir.Runnables[0].Namespace)
+	}
+
+	if fqfn, _ := dir.FQFN("users#get-user"); fqfn != "users#get-user@v0.1.0" {
+		t.Error("wrong FQFN for an inherited namespace, got", fqfn)
+	}
+
+	dir.DefaultNamespace = ""
+	dir.Runnables[0].Namespace = ""
+	if _, found := findProblem(dir.ValidateDetailed(), "function at position 0 missing namespace, and the directive has no defaultNamespace"); !found {
+		t.Error("a runnable without a namespace should fail when there is no defaultNamespace")
+	}
+}
+
+func TestDirectiveDefaultNamespaceInCode(t *testing.T) {
+	dir := &Directive{Identifier: "com.suborbital.test", AppVersion: "v0.1.0", AtmoVersion: "v0.2.0", DefaultNamespace: "users"}
+	dir.AddRunnable("", "get-user").AddHandler(Handler{
+		Input: Input{Type: InputTypeRequest, Method: "GET", Resource: "/user"},
+		Steps: []Executable{{CallableFn: CallableFn{Fn: "users#get-user"}}},
+	})
+
+	if err := dir.Validate(); err != nil {
+		t.Error("the defaultNamespace should apply to runnables added in code:", err)
+	}
+
+	if fqfn, err := dir.FQFN("users#get-user"); err != nil || fqfn != "users#get-user@v0.1.0" {
+		t.Error("the defaultNamespace should apply to the FQFNs of runnables added in code, got", fqfn, err)
+	}
+}
+
+func TestDirectiveWarnings(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-admin
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        as: user
+      - fn: get-admin
+        as: user
+      - fn: get-user
+        with:
+          user: user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Fatal("a directive with only warnings should be valid:", err)
+	}
+
+	warnings := dir.Warnings()
+	if len(warnings) == 0 {
+		t.Fatal("the warnings from Validate should be reported")
+	}
+
+	if !strings.Contains(strings.Join(warnings, "\n"), "overwritten by step 1") {
+		t.Error("wrong warnings, got", warnings)
+	}
+
+	if err := dir.ValidateStrict(); err == nil {
+		t.Error("warnings should fail ValidateStrict")
+	}
+}
+
+func TestInputMatch(t *testing.T) {
+	input := Input{Type: InputTypeRequest, Method: "GET", Resource: "/users/:id/posts/:postId"}
+
+	params, ok := input.Match("get", "/users/42/posts/7")
+	if !ok || len(params) != 2 || params["id"] != "42" || params["postId"] != "7" {
+		t.Error("the path should have matched with its params, got", params, ok)
+	}
+
+	exact := Input{Type: InputTypeRequest, Method: "GET", Resource: "/users"}
+	if params, ok := exact.Match("GET", "/users"); !ok || len(params) != 0 {
+		t.Error("the exact path should have matched without params, got", params, ok)
+	}
+
+	nonMatches := [][2]string{
+		{"POST", "/users/42/posts/7"},
+		{"GET", "/users/42/posts"},
+		{"GET", "/users/42/comments/7"},
+		{"GET", "/users//posts/7"},
+	}
+
+	for _, m := range nonMatches {
+		if _, ok := input.Match(m[0], m[1]); ok {
+			t.Errorf("%s %s should not have matched", m[0], m[1])
+		}
+	}
+}
+
+func TestDirectiveValidatorDuplicateSchedules(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: report
+    namespace: default
+schedules:
+  - name: report
+    every:
+      hours: 1
+    steps:
+      - fn: report
+  - name: digest
+    every:
+      hours: 24
+    steps:
+      - fn: report
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("schedules with distinct names should be valid:", err)
+	}
+
+	dir.Schedules[1].Name = "report"
+	if _, found := findProblem(dir.ValidateDetailed(), "duplicate schedule name report"); !found {
+		t.Error("two schedules with the same name should have failed")
+	}
+}
+
+func TestDirectiveInterpolate(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: fetch
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    headers:
+      X-Region: ${REGION}
+    steps:
+      - fn: fetch
+        args:
+          url: https://${HOST}/users
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    state:
+      apiKey: ${API_KEY}
+    steps:
+      - fn: fetch
+        with:
+          key: apiKey
+`)
+
+	env := map[string]string{"REGION": "us-east", "HOST": "example.com", "API_KEY": "secret"}
+
+	if err := dir.Interpolate(func(name string) string { return env[name] }); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir.Schedules[0].State["apiKey"] != "secret" || dir.Handlers[0].Headers["X-Region"] != "us-east" {
+		t.Error("state and header values should have been interpolated")
+	}
+
+	if dir.Handlers[0].Steps[0].Args["url"] != "https://example.com/users" {
+		t.Error("args values should have been interpolated, got", dir.Handlers[0].Steps[0].Args["url"])
+	}
+
+	unresolved := testDirective(t, `
+schedules:
+  - name: refresh
+    every:
+      minutes: 5
+    state:
+      apiKey: ${API_KEY}
+      token: ${TOKEN}
+    steps:
+      - fn: fetch
+`)
+
+	err := unresolved.Interpolate(func(name string) string { return env[name] })
+	if err == nil || err.Error() != "unresolved environment variables: TOKEN" {
+		t.Error("an unresolved variable should have errored, got", err)
+	}
+
+	if unresolved.Schedules[0].State["apiKey"] != "${API_KEY}" {
+		t.Error("a failed Interpolate should leave the directive unchanged")
+	}
+}
+
+func TestDirectiveValidatorArgs(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: fetch
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    state:
+      id: "1"
+    steps:
+      - fn: fetch
+        with:
+          id: id
+        args:
+          format: json
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("args that don't collide with 'with' values should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[0].Args["id"] = "2"
+	if _, found := findProblem(dir.ValidateDetailed(), "has 'args' value id at step 0 with the same name as a 'with' value"); !found {
+		t.Error("an arg with the same name as a 'with' alias should have failed")
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	steps := []Executable{
+		{CallableFn: CallableFn{Fn: "get-user", As: "user", With: FnWith{"id": "id"}}},
+		{CallableFn: CallableFn{Fn: "get-posts", With: FnWith{"user": "user"}}},
+	}
+
+	knownFns := map[string]bool{"get-user": true, "get-posts": true}
+
+	if problems := ValidateSteps(StepContextHandler, "GET /user", steps, map[string]bool{"id": true}, knownFns); len(problems) != 0 {
+		t.Error("valid steps should have no problems, got", problems)
+	}
+
+	problems := ValidateSteps(StepContextSchedule, "refresh", steps, map[string]bool{}, knownFns)
+	if len(problems) != 1 || problems[0].Kind != ProblemKindSchedule || problems[0].Name != "refresh" {
+		t.Fatal("expected 1 schedule problem, got", problems)
+	}
+
+	if !strings.Contains(problems[0].Message, "schedule for refresh has 'with' value at step 0") {
+		t.Error("the problem should use the step context, got", problems[0].Message)
+	}
+
+	steps[0].Response = "user"
+	if _, found := findProblem(ValidateSteps(StepContextSchedule, "refresh", steps, map[string]bool{"id": true}, knownFns), "only allowed in handlers"); !found {
+		t.Error("the schedule context should not allow step responses")
+	}
+}
+
+func TestDirectiveValidatorFQFNSteps(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+  - name: auth
+    namespace: shared
+    version: v1.2.0
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: shared#auth@v1.2.0
+      - fn: db#get-user@v0.1.0
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("steps referencing the full FQFNs of runnables should be valid:", err)
+	}
+
+	if r, err := dir.Runnable("shared#auth@v1.2.0"); err != nil || r.Name != "auth" {
+		t.Error("the runnable should be found by its FQFN, got", r, err)
+	}
+
+	dir.Handlers[0].Steps[0].Fn = "shared#auth@v1.1.0"
+	if _, found := findProblem(dir.ValidateDetailed(), "that does not exist: shared#auth@v1.1.0"); !found {
+		t.Error("a FQFN with the wrong version should have failed")
+	}
+}
+
+func TestDirectiveMatchRequest(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users/:id
+    steps:
+      - fn: get-user
+  - type: stream
+    resource: /users/:id
+    steps:
+      - fn: get-user
+`)
+
+	h, params, err := dir.MatchRequest("get", "/users/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h != &dir.Handlers[0] || params["id"] != "42" {
+		t.Error("the GET handler should have matched, got", h, params)
+	}
+
+	if _, _, err := dir.MatchRequest("POST", "/users/42"); err == nil || err.Error() != "no handler for method POST on path /users/42" {
+		t.Error("a method mismatch should have errored, got", err)
+	}
+
+	if _, _, err := dir.MatchRequest("GET", "/posts/42"); err == nil || err.Error() != "no handler for path /posts/42" {
+		t.Error("a path without a route should have errored, got", err)
+	}
+}
+
+func TestDirectiveValidateStrictImplicitResponse(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        onErr:
+          any: continue
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("an implicit response that continues on error should only fail ValidateStrict:", err)
+	}
+
+	err := dir.ValidateStrict()
+	if err == nil || !strings.Contains(err.Error(), "responds with the output of its last step, which continues on error") {
+		t.Error("an implicit response that continues on error should have failed ValidateStrict, got", err)
+	}
+
+	dir.Handlers[0].Steps[0].OnErr = nil
+	if err := dir.ValidateStrict(); err != nil {
+		t.Error("an implicit response that returns on error should be valid:", err)
+	}
+}
+
+func TestDirectiveAnalyzeHandler(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-posts
+    namespace: default
+  - name: render
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    state:
+      id: "1"
+    response: page
+    steps:
+      - fn: get-user
+        as: user
+        with:
+          id: id
+      - group:
+          - fn: get-posts
+            as: posts
+            with:
+              user: user
+          - fn: get-user
+            as: friend
+      - fn: render
+        as: page
+        with:
+          user: user
+          posts: posts
+`)
+
+	expected := []StepAnalysis{
+		{AvailableBefore: []string{"id"}, Produces: []string{"user"}, References: []string{"id"}},
+		{AvailableBefore: []string{"id", "user"}, Produces: []string{"friend", "posts"}, References: []string{"user"}},
+		{AvailableBefore: []string{"friend", "id", "posts", "user"}, Produces: []string{"page"}, References: []string{"posts", "user"}},
+	}
+
+	if analysis := dir.AnalyzeHandler(dir.Handlers[0]); !reflect.DeepEqual(analysis, expected) {
+		t.Errorf("wrong analysis, got %+v", analysis)
+	}
+}
+
+func TestUnmarshalAll(t *testing.T) {
+	directives, err := UnmarshalAll([]byte(testDirectiveHeader + `
+runnables:
+  - name: get-user
+    namespace: db
+---
+identifier: com.suborbital.other
+appVersion: v0.2.0
+atmoVersion: v0.2.0
+runnables:
+  - name: send-email
+    namespace: mail
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(directives) != 2 || directives[1].Identifier != "com.suborbital.other" {
+		t.Fatal("expected 2 directives, got", directives)
+	}
+
+	if fqfn, _ := directives[1].FQFN("mail#send-email"); fqfn != "mail#send-email@v0.2.0" {
+		t.Error("FQFNs should be calculated for each directive, got", fqfn)
+	}
+
+	_, err = UnmarshalAll([]byte(testDirectiveHeader + `
+---
+identifier: [com.suborbital.other
+`))
+	if err == nil || !strings.Contains(err.Error(), "failed to unmarshal document 1") {
+		t.Error("a malformed document should have errored with its index, got", err)
+	}
+}
+
+func TestDirectiveLint(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: send-email
+    namespace: default
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    description: Returns the current user
+    steps:
+      - fn: get-user
+  - type: request
+    method: POST
+    resource: /user
+    steps:
+      - fn: get-user
+schedules:
+  - name: poll
+    every:
+      seconds: 2
+    steps:
+      - fn: send-email
+`)
+
+	rules := map[string]string{}
+	for _, s := range dir.Lint() {
+		if s.Rule == LintRuleShortInterval {
+			if s.Severity != SeverityWarning {
+				t.Errorf("a short interval should be a warning: %+v", s)
+			}
+		} else if s.Severity != SeverityInfo {
+			t.Errorf("lint suggestions should be informational: %+v", s)
+		}
+
+		rules[s.Rule] = s.Message
+	}
+
+	expected := map[string]string{
+		LintRuleRunnableOrder:       "runnables are not listed in alphabetical order",
+		LintRuleHandlerDescription:  "handler for POST /user has no description",
+		LintRuleScheduleDescription: "schedule poll has no description",
+		LintRuleShortInterval:       "schedule poll runs every 2 seconds",
+	}
+
+	for rule, message := range expected {
+		if !strings.Contains(rules[rule], message) {
+			t.Errorf("expected a %s suggestion containing %q, got %q", rule, message, rules[rule])
+		}
+	}
+
+	if len(rules) != len(expected) {
+		t.Error("unexpected suggestions", rules)
+	}
+
+	if err := dir.Validate(); err != nil {
+		t.Error("lint suggestions should not affect validity:", err)
+	}
+}
+
+func TestDirectiveValidatorDNSNames(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db-2
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: db-2#get-user
+`)
+
+	if err := dir.ValidateStrict(); err != nil {
+		t.Error("DNS label names should be valid:", err)
+	}
+
+	for _, name := range []string{"getUser", "get_user", "get/user", "-get-user", strings.Repeat("a", 64)} {
+		dir.Runnables[0].Name = name
+		dir.Handlers[0].Steps[0].Fn = "db-2#" + name
+		dir.calculateFQFNs()
+
+		if err := dir.Validate(); err == nil || !strings.Contains(err.Error(), "has a name that is not a valid DNS label") {
+			t.Errorf("the name %q should have failed, got %v", name, err)
+		}
+
+		if err := dir.ValidateWithOptions(ValidateOptions{AllowNonDNSNames: true}); err != nil {
+			t.Errorf("the name %q should be allowed by AllowNonDNSNames: %s", name, err)
+		}
+	}
+
+	dir.Runnables[0].Name = "get-user"
+	dir.Runnables[0].Namespace = "DB"
+	dir.Handlers[0].Steps[0].Fn = "DB#get-user"
+	dir.calculateFQFNs()
+
+	if err := dir.Validate(); err == nil || !strings.Contains(err.Error(), "has a namespace that is not a valid DNS label") {
+		t.Error("an uppercase namespace should have failed, got", err)
+	}
+
+	if err := dir.ValidateWithOptions(ValidateOptions{AllowNonDNSNames: true}); err != nil {
+		t.Error("an uppercase namespace should be allowed by AllowNonDNSNames:", err)
+	}
+}
+
+func TestDirectiveVersionedHandlers(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users/:id
+    version: v1.0.0
+    steps:
+      - fn: get-user
+  - type: request
+    method: GET
+    resource: /users/:id
+    version: v2.0.0
+    steps:
+      - fn: get-user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("handlers for different versions should coexist:", err)
+	}
+
+	doc, err := dir.ToOpenAPI()
+	if err != nil {
+		t.Fatal("versioned handlers should have distinct operations:", err)
+	}
+
+	if !strings.Contains(string(doc), `"/v1.0.0/users/{id}"`) || !strings.Contains(string(doc), `"/v2.0.0/users/{id}"`) {
+		t.Error("the paths should be prefixed with the handlers' versions, got", string(doc))
+	}
+
+	if _, found := dir.HandlerFor("GET", "/users/:id"); found {
+		t.Error("HandlerFor should not pick one of several versions")
+	}
+
+	if h, found := dir.HandlerForVersion("GET", "/users/:id", "v2.0.0"); !found || h.Version != "v2.0.0" {
+		t.Error("HandlerForVersion should find the v2.0.0 handler")
+	}
+
+	if _, _, err := dir.MatchRequest("GET", "/users/42"); err == nil || !strings.Contains(err.Error(), "more than one version") {
+		t.Error("MatchRequest should not pick one of several versions, got", err)
+	}
+
+	h, params, err := dir.MatchRequestVersion("GET", "/users/42", "v1.0.0")
+	if err != nil || h.Version != "v1.0.0" || params["id"] != "42" {
+		t.Error("MatchRequestVersion should match the v1.0.0 handler, got", h, params, err)
+	}
+
+	dir.Handlers[1].Version = "v1.0.0"
+	if _, found := findProblem(dir.ValidateDetailed(), "duplicate handler for GET /users/:id@v1.0.0 found"); !found {
+		t.Error("handlers for the same version should conflict")
+	}
+
+	dir.Handlers[1].Version = "2"
+	if _, found := findProblem(dir.ValidateDetailed(), "has a version that is not a valid semantic version"); !found {
+		t.Error("an invalid handler version should have failed")
+	}
+}
+
+func TestDirectiveNamespaces(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+  - name: send-email
+    namespace: mail
+  - name: get-posts
+    namespace: db
+  - name: render
+    namespace: default
+`)
+
+	if namespaces := dir.Namespaces(); strings.Join(namespaces, ",") != "db,default,mail" {
+		t.Error("wrong namespaces, got", namespaces)
+	}
+}
+
+func TestDirectiveValidatorGoto(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: charge
+    namespace: default
+  - name: refund
+    namespace: default
+handlers:
+  - type: request
+    method: POST
+    resource: /charge
+    steps:
+      - fn: charge
+        onErr:
+          any: goto:cleanup
+      - fn: refund
+        as: cleanup
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a goto with a later target should be valid:", err)
+	}
+
+	dir.Handlers[0].Steps[0].OnErr.Any = "goto:rollback"
+	if _, found := findProblem(dir.ValidateDetailed(), "with error directive goto:rollback, but no later step has 'as' value rollback"); !found {
+		t.Error("a goto without a target should have failed")
+	}
+
+	dir.Handlers[0].Steps[0].OnErr.Any = "goto:cleanup"
+	dir.Handlers[0].Steps[0], dir.Handlers[0].Steps[1] = dir.Handlers[0].Steps[1], dir.Handlers[0].Steps[0]
+	if _, found := findProblem(dir.ValidateDetailed(), "no later step has 'as' value cleanup"); !found {
+		t.Error("a goto to an earlier step should have failed")
+	}
+}
+
+func TestDirectiveUnmarshalStrict(t *testing.T) {
+	valid := []byte(testDirectiveHeader + `
+runnables:
+  - name: get-user
+    namespace: default
+`)
+
+	dir := &Directive{}
+	if err := dir.UnmarshalStrict(valid); err != nil {
+		t.Error(err)
+	}
+
+	misspelled := []byte(testDirectiveHeader + `
+runables:
+  - name: get-user
+    namespace: default
+`)
+
+	if err := dir.UnmarshalStrict(misspelled); err == nil || !strings.Contains(err.Error(), "runables") {
+		t.Error("a misspelled key should have errored, got", err)
+	}
+
+	if err := dir.Unmarshal(misspelled); err != nil {
+		t.Error("Unmarshal should still ignore unknown keys:", err)
+	}
+}
+
+func TestParseWithAliasIdentifiers(t *testing.T) {
+	if _, err := ParseWith([]string{"user_id: id", "Token-2: token"}); err != nil {
+		t.Error("valid aliases should parse:", err)
+	}
+
+	if _, err := ParseWith([]string{"my var: foo"}); err == nil || !strings.Contains(err.Error(), `has alias "my var", which is not a valid identifier`) {
+		t.Error("an alias with a space should have errored, got", err)
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	knownFns := map[string]bool{"report": true}
+
+	valid := Schedule{Name: "report", Every: ScheduleEvery{Hours: 1}, Steps: []Executable{{CallableFn: CallableFn{Fn: "report"}}}}
+	if errs := valid.Validate(knownFns); len(errs) != 0 {
+		t.Error("a valid schedule should have no errors, got", errs)
+	}
+
+	cases := map[string]Schedule{
+		"has no name":              {Every: ScheduleEvery{Hours: 1}, Steps: valid.Steps},
+		"missing steps":            {Name: "report", Every: ScheduleEvery{Hours: 1}},
+		"has no 'every' or 'cron'": {Name: "report", Steps: valid.Steps},
+		"lists fn at step 0 that does not exist: missing": {Name: "report", Every: ScheduleEvery{Hours: 1}, Steps: []Executable{{CallableFn: CallableFn{Fn: "missing"}}}},
+		"has negative 'every' values":                     {Name: "report", Every: ScheduleEvery{Hours: -1}, Steps: valid.Steps},
+		"has both 'cron' and 'every'":                     {Name: "report", Every: ScheduleEvery{Hours: 1}, Cron: "0 * * * *", Steps: valid.Steps},
+		"has an invalid 'cron' value:":                    {Name: "report", Cron: "every hour", Steps: valid.Steps},
+	}
+
+	for message, s := range cases {
+		errs := s.Validate(knownFns)
+
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), message) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected an error containing %q, got %v", message, errs)
+		}
+	}
+}
+
+func TestDirectiveMiddleware(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: auth
+    namespace: default
+  - name: get-user
+    namespace: default
+middleware:
+  - fn: auth
+    as: session
+    with:
+      token: token
+    args:
+      issuer: ${ISSUER}
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        with:
+          session: session
+`)
+
+	if _, found := findProblem(dir.ValidateDetailed(), "middleware for all handlers has 'with' value at step 0 referencing a key that is not yet available in the handler's state: token"); !found {
+		t.Error("middleware should be validated with an empty initial state")
+	}
+
+	dir.Middleware[0].With = nil
+	if err := dir.Validate(); err != nil {
+		t.Error("a handler referencing a key produced by middleware should be valid:", err)
+	}
+
+	if available := dir.AnalyzeHandler(dir.Handlers[0])[0].AvailableBefore; strings.Join(available, ",") != "session" {
+		t.Error("the middleware's keys should be available before the first step, got", available)
+	}
+
+	if keys := dir.RequiredInputKeys(dir.Handlers[0]); len(keys) != 0 {
+		t.Error("the middleware's keys should not be required from the input, got", keys)
+	}
+
+	if keys := dir.Handlers[0].RequiredInputKeys(); strings.Join(keys, ",") != "session" {
+		t.Error("the handler alone should require the middleware's keys, got", keys)
+	}
+
+	if err := dir.Interpolate(func(string) string { return "" }); err == nil || !strings.Contains(err.Error(), "ISSUER") {
+		t.Error("the middleware's args should be interpolated, got", err)
+	}
+
+	dir.Middleware = nil
+	if _, found := findProblem(dir.ValidateDetailed(), "not yet available in the handler's state: session"); !found {
+		t.Error("a handler referencing a key that no middleware produces should have failed")
+	}
+}
+
+func TestDirectiveValidateStrictUnconsumedOutputs(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: get-posts
+    namespace: default
+  - name: render
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+        as: user
+      - fn: get-posts
+        as: posts
+      - fn: render
+        as: page
+        with:
+          user: user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("unconsumed outputs should only fail ValidateStrict:", err)
+	}
+
+	err := dir.ValidateStrict()
+	if err == nil || !strings.Contains(err.Error(), "has step 1 producing posts, which is never used by a later step or the response") {
+		t.Error("an unconsumed intermediate output should have failed ValidateStrict, got", err)
+	}
+
+	if strings.Contains(err.Error(), "producing user") || strings.Contains(err.Error(), "producing page") {
+		t.Error("consumed outputs and the last step's output should not be reported, got", err)
+	}
+
+	dir.Handlers[0].Steps[2].With["posts"] = "posts"
+	if err := dir.ValidateStrict(); err != nil {
+		t.Error("consumed outputs should be valid:", err)
+	}
+}
+
+func TestDirectiveEnabled(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+  - type: request
+    method: POST
+    resource: /user
+    enabled: false
+    steps:
+      - fn: create-user
+schedules:
+  - name: report
+    every:
+      hours: 1
+    steps:
+      - fn: get-user
+  - name: cleanup
+    enabled: false
+    every:
+      hours: 1
+    steps:
+      - fn: delete-users
+`)
+
+	if active := dir.ActiveHandlers(); len(active) != 1 || active[0].Input.Method != "GET" {
+		t.Error("only the enabled handler should be active, got", active)
+	}
+
+	if active := dir.ActiveSchedules(); len(active) != 1 || active[0].Name != "report" {
+		t.Error("only the enabled schedule should be active, got", active)
+	}
+
+	if err := dir.Validate(); err == nil || !strings.Contains(err.Error(), "create-user") || !strings.Contains(err.Error(), "delete-users") {
+		t.Error("disabled handlers and schedules should be validated by default, got", err)
+	}
+
+	if err := dir.ValidateWithOptions(ValidateOptions{SkipDisabledSteps: true}); err != nil {
+		t.Error("the steps of disabled handlers and schedules should have been skipped:", err)
+	}
+
+	enabled := true
+	dir.Handlers[1].Enabled = &enabled
+	if err := dir.ValidateWithOptions(ValidateOptions{SkipDisabledSteps: true}); err == nil || !strings.Contains(err.Error(), "create-user") {
+		t.Error("the steps of an explicitly enabled handler should be validated, got", err)
+	}
+}
+
+func TestForEachIterationFn(t *testing.T) {
+	f := ForEach{
+		In:    "users",
+		Fn:    "send-email",
+		As:    "results",
+		OnErr: &FnOnErr{Any: "continue"},
+	}
+
+	fn := f.IterationFn()
+
+	if fn.Fn != "send-email" || fn.As != "results" {
+		t.Error("the iteration should call the ForEach's fn and store its results, got", fn)
+	}
+
+	if len(fn.With) != 1 || fn.With["users"] != "users" {
+		t.Error("the iteration should receive the element under the 'in' name, got", fn.With)
+	}
+
+	if fn.OnErr == nil || fn.OnErr.Any != "continue" {
+		t.Error("the iteration should use the ForEach's onErr, got", fn.OnErr)
+	}
+
+	fn.OnErr.Any = "return"
+	if f.OnErr.Any != "continue" {
+		t.Error("changing the iteration's onErr should not change the ForEach")
+	}
+}
+
+func TestDirectiveValidatorLookalikeFns(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /
+    steps:
+      - fn: get-user
+  - type: request
+    method: GET
+    resource: /users
+    steps:
+      - fn: users
+schedules:
+  - name: nightly-report
+    every:
+      hours: 24
+    steps:
+      - fn: get-user
+  - name: cleanup
+    every:
+      hours: 24
+    steps:
+      - fn: nightly-report
+`)
+
+	problems := dir.ValidateDetailed()
+
+	if _, found := findProblem(problems, "has fn users at step 0, which looks like a handler resource rather than a function"); !found {
+		t.Error("a handler resource used as a fn should have been reported", problems)
+	}
+
+	if _, found := findProblem(problems, "has fn nightly-report at step 0, which looks like a schedule name rather than a function"); !found {
+		t.Error("a schedule name used as a fn should have been reported", problems)
+	}
+
+	if _, found := findProblem(problems, "fn does not exist"); found {
+		t.Error("lookalikes should replace the 'does not exist' problem", problems)
+	}
+
+	dir.Schedules[1].Steps[0].Fn = ""
+	for _, p := range dir.ValidateDetailed() {
+		if p.Name == "cleanup" && strings.Contains(p.Message, "looks like a") {
+			t.Error("an empty fn should not look like the '/' resource", p)
+		}
+	}
+}
+
+func TestDirectiveSort(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: send-email
+    namespace: mail
+  - name: get-user
+    namespace: db
+  - name: delete-user
+    namespace: db
+handlers:
+  - type: request
+    method: POST
+    resource: /user
+    steps:
+      - fn: db#get-user
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: db#get-user
+schedules:
+  - name: report
+    every:
+      hours: 1
+    steps:
+      - fn: mail#send-email
+  - name: cleanup
+    every:
+      hours: 1
+    steps:
+      - fn: db#delete-user
+`)
+
+	dir.Sort()
+
+	names := []string{}
+	for _, r := range dir.Runnables {
+		names = append(names, r.Namespace+"#"+r.Name)
+	}
+
+	if strings.Join(names, ",") != "db#delete-user,db#get-user,mail#send-email" {
+		t.Error("runnables sorted incorrectly, got", names)
+	}
+
+	if dir.Handlers[0].Input.Method != "GET" || dir.Handlers[1].Input.Method != "POST" {
+		t.Error("handlers sorted incorrectly, got", dir.Handlers)
+	}
+
+	if dir.Schedules[0].Name != "cleanup" || dir.Schedules[1].Name != "report" {
+		t.Error("schedules sorted incorrectly, got", dir.Schedules)
+	}
+
+	if fqfn, err := dir.FQFN("mail#send-email"); err != nil || fqfn != "mail#send-email@v0.1.0" {
+		t.Error("FQFNs should be recalculated after sorting, got", fqfn)
+	}
+
+	sorted, err := dir.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir.Runnables[0], dir.Runnables[2] = dir.Runnables[2], dir.Runnables[0]
+	dir.Sort()
+
+	resorted, err := dir.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(sorted) != string(resorted) {
+		t.Error("sorting should have a canonical output")
+	}
+}
+
+func TestDirectiveExternalRunnables(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+  - name: send-email
+    namespace: shared
+    source: https://registry.suborbital.dev/shared/send-email.wasm
+handlers:
+  - type: request
+    method: POST
+    resource: /user
+    steps:
+      - fn: get-user
+      - fn: shared#send-email
+`)
+
+	if dir.Runnables[0].IsExternal() || !dir.Runnables[1].IsExternal() {
+		t.Error("only the runnable with a source should be external")
+	}
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a valid external source should be valid:", err)
+	}
+
+	for _, source := range []string{"registry/send-email.wasm", "https://", "://registry"} {
+		dir.Runnables[1].Source = source
+		if _, found := findProblem(dir.ValidateDetailed(), "function shared#send-email has a source that is not a valid URL: "+source); !found {
+			t.Errorf("the source %q should have failed", source)
+		}
+	}
+}
+
+func TestDirectiveValidatorRequireDescriptions(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("descriptions should not be required by default:", err)
+	}
+
+	err := dir.ValidateWithOptions(ValidateOptions{RequireDescriptions: true})
+	if err == nil || !strings.Contains(err.Error(), "handler for GET /user has no description") {
+		t.Error("a handler without a description should have failed, got", err)
+	}
+
+	if strings.Contains(err.Error(), "function") {
+		t.Error("runnable descriptions should only be required by their own option, got", err)
+	}
+
+	if err := dir.ValidateWithOptions(ValidateOptions{RequireRunnableDescriptions: true}); err == nil || !strings.Contains(err.Error(), "function default#get-user has no description") {
+		t.Error("a runnable without a description should have failed, got", err)
+	}
+
+	dir.Handlers[0].Description = "Returns the current user"
+	dir.Runnables[0].Description = "Looks up a user"
+	if err := dir.ValidateWithOptions(ValidateOptions{RequireDescriptions: true, RequireRunnableDescriptions: true}); err != nil {
+		t.Error("described handlers and runnables should be valid:", err)
+	}
+
+	dir.AddHandler(Handler{
+		Input:    Input{Type: InputTypeRequest, Method: "GET", Resource: "/internal/user"},
+		Internal: true,
+		Steps:    []Executable{{CallableFn: CallableFn{Fn: "get-user"}}},
+	})
+
+	if err := dir.ValidateWithOptions(ValidateOptions{RequireDescriptions: true}); err != nil {
+		t.Error("internal handlers should not need a description:", err)
+	}
+}
+
+func TestHandlerCriticalPathLength(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+      - group:
+          - fn: get-posts
+          - fn: get-friends
+          - fn: get-photos
+      - forEach:
+          in: posts
+          fn: render-post
+          as: rendered
+      - fn: render-page
+`)
+
+	if length := dir.Handlers[0].CriticalPathLength(); length != 4 {
+		t.Error("expected a critical path of 4, got", length)
+	}
+
+	empty := Handler{}
+	if length := empty.CriticalPathLength(); length != 0 {
+		t.Error("expected a critical path of 0 for no steps, got", length)
+	}
+}
+
+func TestDirectiveValidatorStreamMethod(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: stream
+    resource: /stream
+    method: POST
+    steps:
+      - fn: get-user
+`)
+
+	if _, found := findProblem(dir.ValidateDetailed(), "handler for resource /stream is of type stream, but specifies the HTTP method POST, which only applies to requests"); !found {
+		t.Error("a stream handler with a method should have failed")
+	}
+
+	dir.Handlers[0].Input.Method = ""
+	if err := dir.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirectiveReplaceRunnable(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: db
+    version: v0.1.0
+`)
+
+	if err := dir.ReplaceRunnable(Runnable{Name: "get-user", Namespace: "db", Version: "v0.2.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dir.Runnables) != 1 || dir.Runnables[0].Version != "v0.2.0" {
+		t.Error("the runnable should have been replaced, got", dir.Runnables)
+	}
+
+	if fqfn, err := dir.FQFN("db#get-user"); err != nil || !strings.HasSuffix(fqfn, "@v0.2.0") {
+		t.Error("the FQFN should use the replacement's version, got", fqfn)
+	}
+
+	if err := dir.ReplaceRunnable(Runnable{Name: "get-user", Namespace: "default"}); err == nil || err.Error() != "fn default#get-user does not exist" {
+		t.Error("replacing a missing runnable should have errored, got", err)
+	}
+}
+
+func TestDirectiveValidateAgainst(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+      - fn: com.suborbital.shared#mail::send-email@v1.0.0
+`)
+
+	if err := dir.Validate(); err == nil {
+		t.Error("a remote fn should not exist without the external set")
+	}
+
+	if err := dir.ValidateAgainst(nil); err == nil {
+		t.Error("a remote fn should not exist with an empty external set")
+	}
+
+	known := map[string]bool{"com.suborbital.shared#mail::send-email@v1.0.0": true}
+	if err := dir.ValidateAgainst(known); err != nil {
+		t.Error("a remote fn in the external set should be valid:", err)
+	}
+
+	if err := dir.ValidateWithOptions(ValidateOptions{KnownFns: known}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirectiveResourceNormalization(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: users
+    steps:
+      - fn: get-user
+  - type: request
+    method: POST
+    resource: //users//:id
+    steps:
+      - fn: get-user
+  - type: stream
+    resource: events
+    steps:
+      - fn: get-user
+`)
+
+	if dir.Handlers[0].Input.Resource != "/users" || dir.Handlers[1].Input.Resource != "/users/:id" {
+		t.Error("request resources should have been normalized, got", dir.Handlers[0].Input.Resource, dir.Handlers[1].Input.Resource)
+	}
+
+	if dir.Handlers[2].Input.Resource != "events" {
+		t.Error("stream resources should have been left as they are, got", dir.Handlers[2].Input.Resource)
+	}
+
+	if err := dir.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	// handlers added in code are not normalized, but must still not duplicate an existing route
+	dir.AddHandler(Handler{
+		Input: Input{Type: InputTypeRequest, Method: "GET", Resource: "users"},
+		Steps: []Executable{{CallableFn: CallableFn{Fn: "get-user"}}},
+	})
+
+	if _, found := findProblem(dir.ValidateDetailed(), "duplicate handler for GET /users found"); !found {
+		t.Error("a handler for 'users' should duplicate the one for '/users'")
+	}
+}
+
+func TestDirectiveValidatorDeterministic(t *testing.T) {
+	body := `
+runnables:
+  - name: get-user
+    namespace: db
+  - name: get-user
+    namespace: db
+  - name: send-email
+    namespace: mail
+  - name: send-email
+    namespace: mail
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: missing
+        with:
+          id: userId
+schedules:
+  - name: report
+    every:
+      minutes: 5
+    steps:
+      - fn: also-missing
+`
+
+	first := testDirective(t, body).Validate()
+	if first == nil {
+		t.Fatal("directive validation should have failed")
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := testDirective(t, body).Validate(); err == nil || err.Error() != first.Error() {
+			t.Fatalf("validating the same directive should always have the same error, got %q and %q", first, err)
+		}
+	}
+
+	dir := testDirective(t, body)
+	if dir.Validate().Error() != dir.Validate().Error() {
+		t.Error("validating a directive twice should have the same error")
+	}
+
+	problems := dir.ValidateDetailed()
+	for i := 1; i < len(problems); i++ {
+		if problemKindOrder[problems[i-1].Kind] > problemKindOrder[problems[i].Kind] {
+			t.Error("problems should be sorted by kind, got", problems)
+		}
+	}
+}
+
+func TestDirectiveValidatorSecrets(t *testing.T) {
+	dir := testDirective(t, `
+secrets:
+  - API_KEY
+runnables:
+  - name: send-email
+    namespace: default
+handlers:
+  - type: request
+    method: POST
+    resource: /email
+    steps:
+      - fn: send-email
+        with:
+          key: secret:API_KEY
+      - fn: send-email
+        as: again
+        with:
+          - secret:API_KEY
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("steps referencing a declared secret should be valid:", err)
+	}
+
+	if with := dir.Handlers[0].Steps[1].With; with["API_KEY"] != "secret:API_KEY" {
+		t.Error("the bare secret form should be aliased to the secret's name, got", with)
+	}
+
+	dir.Secrets = nil
+	problems := dir.ValidateDetailed()
+	if _, found := findProblem(problems, "has fn at step 0 referencing secret API_KEY, which is not declared in the directive's secrets"); !found {
+		t.Error("a step referencing an undeclared secret should have failed", problems)
+	}
+
+	if _, found := findProblem(problems, "has fn at step 1 referencing secret API_KEY"); !found {
+		t.Error("the bare secret form should have been checked", problems)
+	}
+
+	dir.Secrets = []string{"API_KEY", "API_KEY", ""}
+	problems = dir.ValidateDetailed()
+	if _, found := findProblem(problems, "duplicate secret API_KEY found"); !found {
+		t.Error("a duplicate secret should have failed")
+	}
+
+	if _, found := findProblem(problems, "secrets contains an empty name"); !found {
+		t.Error("an empty secret should have failed")
+	}
+}
+
+func TestDirectiveValidateStrictScheduleGroupLast(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-users
+    namespace: default
+  - name: get-posts
+    namespace: default
+schedules:
+  - name: warm-cache
+    every:
+      minutes: 5
+    steps:
+      - group:
+          - fn: get-users
+          - fn: get-posts
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a schedule ending in a group should only fail ValidateStrict:", err)
+	}
+
+	err := dir.ValidateStrict()
+	if err == nil || !strings.Contains(err.Error(), "schedule warm-cache ends with a group at step 0 whose outputs (get-users, get-posts) are never used") {
+		t.Error("a schedule ending in a group should have failed ValidateStrict, got", err)
+	}
+
+	dir.Schedules[0].Steps = append(dir.Schedules[0].Steps, Executable{CallableFn: CallableFn{Fn: "get-users", As: "refreshed", With: FnWith{"posts": "get-posts"}}})
+	if err := dir.ValidateStrict(); err != nil && strings.Contains(err.Error(), "ends with a group") {
+		t.Error("a group that isn't the last step should not be reported, got", err)
+	}
+}
+
+func TestHandlerMaxConcurrency(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /user
+    steps:
+      - fn: get-user
+      - group:
+          - fn: get-posts
+          - fn: get-friends
+          - fn: get-photos
+      - forEach:
+          in: posts
+          fn: render-post
+          as: rendered
+          parallelism: 5
+`)
+
+	if max := dir.Handlers[0].MaxConcurrency(); max != 5 {
+		t.Error("expected the ForEach's parallelism of 5, got", max)
+	}
+
+	dir.Handlers[0].Steps[2].ForEach.Parallelism = 0
+	if max := dir.Handlers[0].MaxConcurrency(); max != 3 {
+		t.Error("expected the group's size of 3, got", max)
+	}
+
+	dir.Handlers[0].Steps = dir.Handlers[0].Steps[:1]
+	if max := dir.Handlers[0].MaxConcurrency(); max != 1 {
+		t.Error("expected a single fn to have a concurrency of 1, got", max)
+	}
+}
+
+func TestDirectiveDecode(t *testing.T) {
+	dir := &Directive{}
+	err := dir.Decode(strings.NewReader(testDirectiveHeader + `
+runnables:
+  - name: get-user
+    namespace: db
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fqfn, err := dir.FQFN("db#get-user"); err != nil || fqfn != "db#get-user@v0.1.0" {
+		t.Error("decoding should calculate FQFNs, got", fqfn, err)
+	}
+
+	if err := (&Directive{}).Decode(strings.NewReader("")); err != io.EOF {
+		t.Error("decoding an empty reader should return io.EOF, got", err)
+	}
+
+	if err := (&Directive{}).Decode(strings.NewReader("runnables: {")); err == nil {
+		t.Error("decoding malformed YAML should have errored")
+	}
+}
+
+func TestDirectiveValidatorSharedNames(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: foo
+    namespace: default
+  - name: foo
+    namespace: bar
+handlers:
+  - type: request
+    method: GET
+    resource: /foo
+    steps:
+      - fn: foo
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("an ambiguous bare name should only be a warning:", err)
+	}
+
+	problem, found := findProblem(dir.ValidateDetailed(), "has fn foo at step 0, which resolves to default#foo but a fn of the same name exists in bar")
+	if !found || problem.Severity != SeverityWarning {
+		t.Error("an ambiguous bare name should have been warned about, got", problem)
+	}
+
+	dir.Handlers[0].Steps[0].Fn = "default#foo"
+	if _, found := findProblem(dir.ValidateDetailed(), "use a namespaced name"); found {
+		t.Error("a namespaced name should not be ambiguous")
+	}
+}
+
+func TestDirectivePublicHandlers(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: get-user
+    namespace: default
+handlers:
+  - type: request
+    method: GET
+    resource: /users/:id
+    steps:
+      - fn: get-user
+  - type: request
+    method: GET
+    resource: /users/admin
+    internal: true
+    steps:
+      - fn: get-user
+  - type: request
+    method: POST
+    resource: /users/:id
+    enabled: false
+    steps:
+      - fn: get-user
+`)
+
+	if public := dir.PublicHandlers(); len(public) != 2 || public[0].Input.Resource != "/users/:id" || public[1].Input.Method != "POST" {
+		t.Error("only the internal handler should be excluded, got", public)
+	}
+
+	if err := dir.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	dir.Handlers[1].Steps[0].Fn = "missing"
+	if err := dir.Validate(); err == nil {
+		t.Error("internal handlers should still be validated")
+	}
+
+	h, params, err := dir.MatchRequest("GET", "/users/admin")
+	if err != nil || h.Internal || params["id"] != "admin" {
+		t.Error("MatchRequest should skip the internal handler, got", h, params, err)
+	}
+
+	if _, _, err := dir.MatchRequest("POST", "/users/42"); err == nil {
+		t.Error("MatchRequest should skip the disabled handler")
+	}
+}
+
+func TestDirectiveValidatorMinScheduleInterval(t *testing.T) {
+	dir := testDirective(t, `
+runnables:
+  - name: poll
+    namespace: default
+schedules:
+  - name: poll
+    every:
+      seconds: 5
+    steps:
+      - fn: poll
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("there should be no minimum interval by default:", err)
+	}
+
+	err := dir.ValidateWithOptions(ValidateOptions{MinScheduleInterval: 30})
+	if err == nil || !strings.Contains(err.Error(), "schedule poll runs every 5 seconds, more often than the minimum interval of 30 seconds") {
+		t.Error("a schedule more frequent than the minimum should have failed, got", err)
+	}
+
+	dir.Schedules[0].Every = ScheduleEvery{Minutes: 1}
+	if err := dir.ValidateWithOptions(ValidateOptions{MinScheduleInterval: 30}); err != nil {
+		t.Error("a schedule within the minimum should be valid:", err)
+	}
+}
+
+func TestDirectiveDefaults(t *testing.T) {
+	dir := testDirective(t, `
+defaults:
+  retries: 3
+  backoffMs: 100
+runnables:
+  - name: charge
+    namespace: default
+handlers:
+  - type: request
+    method: POST
+    resource: /charge
+    steps:
+      - fn: charge
+        onErr:
+          any: retry
+`)
+
+	if err := dir.Validate(); err != nil {
+		t.Error("a retry should inherit the default policy:", err)
+	}
+
+	fn := dir.Handlers[0].Steps[0].CallableFn
+
+	if retries, backoff := dir.RetryPolicy(fn); retries != 3 || backoff != 100 {
+		t.Error("expected the default policy of 3 retries with a 100ms backoff, got", retries, backoff)
+	}
+
+	fn.OnErr.Retries = 5
+	if retries, backoff := dir.RetryPolicy(fn); retries != 5 || backoff != 100 {
+		t.Error("expected the fn's own retries to override the default, got", retries, backoff)
+	}
+
+	if retries, backoff := dir.RetryPolicy(CallableFn{Fn: "charge", OnErr: &FnOnErr{Any: "continue", Retries: 1, BackoffMs: 50}}); retries != 1 || backoff != 50 {
+		t.Error("expected the fn's own onErr to override the default, got", retries, backoff)
+	}
+
+	if retries, backoff := dir.RetryPolicy(CallableFn{Fn: "charge"}); retries != 3 || backoff != 100 {
+		t.Error("a fn without onErr should inherit the default policy, got", retries, backoff)
+	}
+
+	if retries, backoff := (&Directive{}).RetryPolicy(CallableFn{Fn: "charge"}); retries != 0 || backoff != 0 {
+		t.Error("a fn with no onErr or defaults should have no retry policy, got", retries, backoff)
+	}
+
+	dir.Defaults.Retries = -1
+	if _, found := findProblem(dir.ValidateDetailed(), "'defaults.retries' or 'defaults.backoffMs' value is negative"); !found {
+		t.Error("a negative default should have failed")
+	}
+}
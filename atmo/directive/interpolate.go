@@ -0,0 +1,78 @@
+package directive
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envVarRegex matches a '${VAR}' reference to an environment variable
+var envVarRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Interpolate substitutes '${VAR}' references in the Directive's literal values (handler and schedule
+// State values, handler Headers values, and the Args values of middleware, handler, and schedule fns) with
+// the result of getenv (usually os.Getenv). A variable for which getenv returns an empty string is unresolved,
+// and if any are found an error naming them is returned and the Directive is left unchanged. Interpolate should be called before Validate
+func (d *Directive) Interpolate(getenv func(string) string) error {
+	literals := d.literalMaps()
+
+	unresolved := map[string]bool{}
+	for _, m := range literals {
+		for _, v := range m {
+			for _, match := range envVarRegex.FindAllStringSubmatch(v, -1) {
+				if getenv(match[1]) == "" {
+					unresolved[match[1]] = true
+				}
+			}
+		}
+	}
+
+	if len(unresolved) > 0 {
+		names := make([]string, 0, len(unresolved))
+		for name := range unresolved {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		return fmt.Errorf("unresolved environment variables: %s", strings.Join(names, ", "))
+	}
+
+	for _, m := range literals {
+		for k, v := range m {
+			m[k] = envVarRegex.ReplaceAllStringFunc(v, func(ref string) string {
+				return getenv(envVarRegex.FindStringSubmatch(ref)[1])
+			})
+		}
+	}
+
+	return nil
+}
+
+// literalMaps returns every map in the Directive whose values are literals rather than state keys
+func (d *Directive) literalMaps() []map[string]string {
+	maps := []map[string]string{}
+
+	addSteps := func(steps []Executable) {
+		for _, s := range steps {
+			for _, fn := range s.callableFns() {
+				maps = append(maps, fn.Args)
+			}
+		}
+	}
+
+	addSteps(d.Middleware)
+
+	for _, h := range d.Handlers {
+		maps = append(maps, h.State, h.Headers)
+		addSteps(h.Steps)
+	}
+
+	for _, s := range d.Schedules {
+		maps = append(maps, s.State)
+		addSteps(s.Steps)
+	}
+
+	return maps
+}
@@ -0,0 +1,68 @@
+package directive
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LintRuleRunnableOrder and others identify the rules that Lint checks
+const (
+	LintRuleRunnableOrder       = "runnable-order"
+	LintRuleHandlerDescription  = "handler-description"
+	LintRuleScheduleDescription = "schedule-description"
+	LintRuleShortInterval       = "short-interval"
+)
+
+// SeverityInfo is the severity of a LintSuggestion that is purely a matter of style
+const SeverityInfo = "info"
+
+// LintShortInterval is the 'every' interval (in seconds) below which Lint suggests a schedule is running too often
+var LintShortInterval = 10
+
+// LintSuggestion is an opinionated suggestion about the style of a Directive, which does not affect its validity
+type LintSuggestion struct {
+	Rule     string
+	Message  string
+	Severity string
+}
+
+// Lint returns style suggestions for the Directive. It is independent of Validate, and
+// a Directive that Lint has suggestions for can still be valid
+func (d *Directive) Lint() []LintSuggestion {
+	suggestions := []LintSuggestion{}
+
+	suggest := func(rule, severity, format string, args ...interface{}) {
+		suggestions = append(suggestions, LintSuggestion{Rule: rule, Message: fmt.Sprintf(format, args...), Severity: severity})
+	}
+
+	runnables := make([]string, len(d.Runnables))
+	for i, r := range d.Runnables {
+		runnables[i] = fmt.Sprintf("%s#%s", d.runnableNamespace(r), r.Name)
+	}
+
+	if !sort.StringsAreSorted(runnables) {
+		suggest(LintRuleRunnableOrder, SeverityInfo, "runnables are not listed in alphabetical order")
+	}
+
+	for _, h := range d.Handlers {
+		if h.Description == "" {
+			suggest(LintRuleHandlerDescription, SeverityInfo, "handler for %s has no description", h.Input.name())
+		}
+	}
+
+	for _, s := range d.Schedules {
+		if s.Description == "" {
+			suggest(LintRuleScheduleDescription, SeverityInfo, "schedule %s has no description", s.Name)
+		}
+
+		if s.Cron != "" {
+			continue
+		}
+
+		if seconds, err := s.NumberOfSecondsChecked(); err == nil && seconds > 0 && seconds < LintShortInterval {
+			suggest(LintRuleShortInterval, SeverityWarning, "schedule %s runs every %d seconds, which is more often than every %d seconds", s.Name, seconds, LintShortInterval)
+		}
+	}
+
+	return suggestions
+}
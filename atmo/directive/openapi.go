@@ -0,0 +1,109 @@
+package directive
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// openAPIVersion is the version of the OpenAPI specification that ToOpenAPI generates
+const openAPIVersion = "3.0.3"
+
+type openAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    openAPIInfo                            `json:"info"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIOperation struct {
+	Description string                     `json:"description,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string            `json:"name"`
+	In       string            `json:"in"`
+	Required bool              `json:"required"`
+	Schema   map[string]string `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string `json:"description"`
+}
+
+// ToOpenAPI generates a minimal OpenAPI 3 document (as JSON) describing the Directive's public request handlers.
+// Handlers of other types have no HTTP representation and are skipped, as are internal handlers. So that
+// the operations of each API version are distinct, a handler with a Version is described under a path
+// prefixed with it, i.e. '/v2.0.0/users'
+func (d *Directive) ToOpenAPI() ([]byte, error) {
+	doc := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   d.Identifier,
+			Version: d.AppVersion,
+		},
+		Paths: map[string]map[string]openAPIOperation{},
+	}
+
+	for _, h := range d.PublicHandlers() {
+		if h.Input.Type != InputTypeRequest {
+			continue
+		}
+
+		path, params := openAPIPath(h.Input.Resource)
+		if h.Version != "" {
+			path = fmt.Sprintf("/%s%s", h.Version, path)
+		}
+
+		if _, exists := doc.Paths[path]; !exists {
+			doc.Paths[path] = map[string]openAPIOperation{}
+		}
+
+		method := strings.ToLower(h.Input.Method)
+
+		if _, exists := doc.Paths[path][method]; exists {
+			return nil, fmt.Errorf("handler for %s duplicates the %s operation for path %s", h.Input.name(), method, path)
+		}
+
+		op := openAPIOperation{
+			Description: h.Description,
+			Responses: map[string]openAPIResponse{
+				"200": {Description: "OK"},
+			},
+		}
+
+		for _, param := range params {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name:     param,
+				In:       "path",
+				Required: true,
+				Schema:   map[string]string{"type": "string"},
+			})
+		}
+
+		doc.Paths[path][method] = op
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// openAPIPath converts a resource such as '/users/:id' into the OpenAPI form '/users/{id}', returning the path param names
+func openAPIPath(resource string) (string, []string) {
+	segments := strings.Split(resource, "/")
+	params := []string{}
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") && len(segment) > 1 {
+			params = append(params, segment[1:])
+			segments[i] = fmt.Sprintf("{%s}", segment[1:])
+		}
+	}
+
+	return strings.Join(segments, "/"), params
+}
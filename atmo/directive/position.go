@@ -0,0 +1,156 @@
+package directive
+
+import (
+	"fmt"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// sourcePositions records the lines in the source YAML of a Directive's elements, so that problems can refer to them.
+// Elements are identified the same way as in problems (runnables by namespaced name, handlers by key, and schedules
+// by name) rather than by index, so the lines are still correct after the Directive is sorted or added to
+type sourcePositions struct {
+	runnables  map[string][]int
+	handlers   map[string][]elementPosition
+	schedules  map[string][]elementPosition
+	middleware []int
+}
+
+// elementPosition is the line of a handler or schedule, and the line of each of its steps
+type elementPosition struct {
+	line  int
+	steps []int
+}
+
+// recordPositions finds the lines of the Directive's elements in the YAML it was unmarshalled from.
+// yaml.v2 does not expose positions, so the YAML is parsed a second time as a yaml.v3 node tree
+func (d *Directive) recordPositions(in []byte) {
+	doc := &yaml3.Node{}
+	if err := yaml3.Unmarshal(in, doc); err != nil {
+		d.positions = nil
+		return
+	}
+
+	d.positions = positionsOf(doc, d)
+}
+
+// positionsOf returns the positions of the Directive's elements in a yaml.v3 document node
+func positionsOf(doc *yaml3.Node, d *Directive) *sourcePositions {
+	positions := &sourcePositions{
+		runnables: map[string][]int{},
+		handlers:  map[string][]elementPosition{},
+		schedules: map[string][]elementPosition{},
+	}
+
+	if doc.Kind != yaml3.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml3.MappingNode {
+		return positions
+	}
+
+	root := doc.Content[0]
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		items := sequenceItems(root.Content[i+1])
+
+		switch root.Content[i].Value {
+		case "runnables":
+			for j, item := range items {
+				if j < len(d.Runnables) {
+					namespaced := fmt.Sprintf("%s#%s", d.runnableNamespace(d.Runnables[j]), d.Runnables[j].Name)
+					positions.runnables[namespaced] = append(positions.runnables[namespaced], item.Line)
+				}
+			}
+		case "handlers":
+			for j, item := range items {
+				if j < len(d.Handlers) {
+					key := d.Handlers[j].key()
+					positions.handlers[key] = append(positions.handlers[key], elementPositionOf(item))
+				}
+			}
+		case "schedules":
+			for j, item := range items {
+				if j < len(d.Schedules) {
+					name := d.Schedules[j].Name
+					positions.schedules[name] = append(positions.schedules[name], elementPositionOf(item))
+				}
+			}
+		case "middleware":
+			for _, item := range items {
+				positions.middleware = append(positions.middleware, item.Line)
+			}
+		}
+	}
+
+	return positions
+}
+
+// elementPositionOf returns the position of a handler or schedule node and its steps
+func elementPositionOf(node *yaml3.Node) elementPosition {
+	position := elementPosition{line: node.Line}
+
+	if node.Kind == yaml3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	if node.Kind != yaml3.MappingNode {
+		return position
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "steps" {
+			for _, step := range sequenceItems(node.Content[i+1]) {
+				position.steps = append(position.steps, step.Line)
+			}
+		}
+	}
+
+	return position
+}
+
+// sequenceItems returns the items of a sequence node, following an alias to one
+func sequenceItems(node *yaml3.Node) []*yaml3.Node {
+	if node.Kind == yaml3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	if node.Kind != yaml3.SequenceNode {
+		return nil
+	}
+
+	return node.Content
+}
+
+// runnable returns the position of the nth (starting at 0) runnable with the given namespaced name
+func (p *sourcePositions) runnable(namespaced string, n int) elementPosition {
+	if p == nil || n >= len(p.runnables[namespaced]) {
+		return elementPosition{}
+	}
+
+	return elementPosition{line: p.runnables[namespaced][n]}
+}
+
+// handler returns the position of the nth (starting at 0) handler with the given key
+func (p *sourcePositions) handler(key string, n int) elementPosition {
+	if p == nil || n >= len(p.handlers[key]) {
+		return elementPosition{}
+	}
+
+	return p.handlers[key][n]
+}
+
+// schedule returns the position of the nth (starting at 0) schedule with the given name
+func (p *sourcePositions) schedule(name string, n int) elementPosition {
+	if p == nil || n >= len(p.schedules[name]) {
+		return elementPosition{}
+	}
+
+	return p.schedules[name][n]
+}
+
+// middlewareSteps returns the lines of the middleware steps
+func (p *sourcePositions) middlewareSteps() []int {
+	if p == nil {
+		return nil
+	}
+
+	return p.middleware
+}
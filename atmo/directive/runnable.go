@@ -0,0 +1,23 @@
+package directive
+
+// Runnable is the structure of a .runnable.yaml file
+type Runnable struct {
+	Name       string `yaml:"name" json:"name"`
+	Namespace  string `yaml:"namespace" json:"namespace"`
+	Lang       string `yaml:"lang" json:"lang"`
+	APIVersion string `yaml:"apiVersion,omitempty" json:"apiVersion,omitempty"`
+
+	// Version pins the runnable to a version other than the directive's appVersion
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+
+	// Description is a human-readable explanation of what the runnable does
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	// Source is the URL of an externally built runnable, which is fetched rather than built from the project
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+}
+
+// IsExternal returns true if the runnable is fetched from its Source rather than built locally
+func (r *Runnable) IsExternal() bool {
+	return r.Source != ""
+}
@@ -0,0 +1,9 @@
+module github.com/suborbital/atmo
+
+go 1.16
+
+require (
+	golang.org/x/mod v0.4.2
+	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
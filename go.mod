@@ -10,3 +10,6 @@ require (
 	golang.org/x/mod v0.4.2
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+// the directive package is developed in ./atmo until its changes are released upstream
+replace github.com/suborbital/atmo => ./atmo
@@ -37,13 +37,22 @@ func readDirectiveFile(cwd string) (*directive.Directive, error) {
 }
 
 // AugmentAndValidateDirectiveFns ensures that all functions referenced in a handler exist
-// in the project and then adds the function list to the provided directive
+// in the project and then adds the function list to the provided directive. External runnables
+// (those with a Source) are fetched rather than built, so they are kept without a local function
 func AugmentAndValidateDirectiveFns(dxe *directive.Directive, fns []RunnableDir) error {
 	fnMap := map[string]bool{}
 	for _, fn := range fns {
 		fnMap[fn.Name] = true
 	}
 
+	external := []directive.Runnable{}
+	for _, r := range dxe.Runnables {
+		if r.IsExternal() && !fnMap[r.Name] {
+			external = append(external, r)
+			fnMap[r.Name] = true
+		}
+	}
+
 	handlerFns := getHandlerFnList(dxe)
 
 	for _, fn := range handlerFns {
@@ -57,7 +66,7 @@ func AugmentAndValidateDirectiveFns(dxe *directive.Directive, fns []RunnableDir)
 		dirRunnables[i] = *fns[i].Runnable
 	}
 
-	dxe.Runnables = dirRunnables
+	dxe.Runnables = append(dirRunnables, external...)
 
 	return nil
 }